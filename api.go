@@ -0,0 +1,541 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimeRange reads the from and to query params (RFC 3339), defaulting to
+// the last day
+func parseTimeRange(r *http.Request, limits queryLimits) (time.Time, time.Time, error) {
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+		}
+		from = t
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if limits.maxRange > 0 && to.Sub(from) > limits.maxRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("time range can be at most %s", limits.maxRange)
+	}
+	return from, to, nil
+}
+
+// queryLimits bound how much a single request can read from the DB, to protect
+// both the DB and our memory. The handlers buffer their results rather than
+// streaming them, so going over maxRows is still a 400 rather than a truncated
+// 200, and the elevation profile needs every sample for its median filter
+// anyway, so maxRows is also what bounds their memory.
+type queryLimits struct {
+	// longest time range, 0 for no limit
+	maxRange time.Duration
+	// most rows, 0 for no limit
+	maxRows int
+}
+
+// tooManyRows reports whether reading n rows is over the limit, responding
+// with an error if it is
+func (l queryLimits) tooManyRows(rw http.ResponseWriter, n int) bool {
+	if l.maxRows > 0 && n > l.maxRows {
+		http.Error(rw, fmt.Sprintf("more than %d results, request a shorter time range", l.maxRows), http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// queryInt reads an optional non-negative integer query param
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return i, nil
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Printf("error writing JSON response: %s\n", err)
+	}
+}
+
+func internalError(rw http.ResponseWriter, err error) {
+	log.Printf("error handling API request: %s\n", err)
+	http.Error(rw, "internal server error", http.StatusInternalServerError)
+}
+
+type elevationSample struct {
+	Distance  float64 `json:"distance_m"`
+	Elevation float64 `json:"elevation_m"`
+}
+
+// medianFilter replaces each value with the median of the window centered on
+// it, which knocks out single-sample altitude spikes without flattening hills
+func medianFilter(values []float64, window int) []float64 {
+	if window < 2 {
+		return values
+	}
+	out := make([]float64, len(values))
+	buf := make([]float64, 0, window)
+	for i := range values {
+		lo := i - window/2
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + window
+		if hi > len(values) {
+			hi = len(values)
+		}
+		buf = append(buf[:0], values[lo:hi]...)
+		sort.Float64s(buf)
+		out[i] = buf[len(buf)/2]
+	}
+	return out
+}
+
+// elevationHandler returns an elevation profile, elevation against cumulative
+// distance traveled, for a time range
+func elevationHandler(db *sql.DB, limits queryLimits) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, limits)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		window, err := queryInt(r, "window", 0)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT ST_Y(gps_geometry::geometry), ST_X(gps_geometry::geometry), ST_Z(gps_geometry::geometry) FROM gps_logs WHERE gps_timestamp BETWEEN $1 AND $2 AND ST_Z(gps_geometry::geometry) IS NOT NULL ORDER BY gps_timestamp`, from, to)
+		if err != nil {
+			internalError(rw, fmt.Errorf("failed to query elevation: %w", err))
+			return
+		}
+		defer rows.Close()
+
+		samples := make([]elevationSample, 0)
+		var lastLat, lastLon, distance float64
+		for rows.Next() {
+			if limits.tooManyRows(rw, len(samples)+1) {
+				return
+			}
+			var lat, lon, elevation float64
+			if err := rows.Scan(&lat, &lon, &elevation); err != nil {
+				internalError(rw, fmt.Errorf("failed to scan elevation: %w", err))
+				return
+			}
+			if len(samples) > 0 {
+				distance += haversine(lastLat, lastLon, lat, lon)
+			}
+			lastLat, lastLon = lat, lon
+			samples = append(samples, elevationSample{Distance: distance, Elevation: elevation})
+		}
+		if err := rows.Err(); err != nil {
+			internalError(rw, fmt.Errorf("failed to read elevation: %w", err))
+			return
+		}
+
+		if window > 1 {
+			elevations := make([]float64, len(samples))
+			for i, s := range samples {
+				elevations[i] = s.Elevation
+			}
+			for i, e := range medianFilter(elevations, window) {
+				samples[i].Elevation = e
+			}
+		}
+
+		writeJSON(rw, samples)
+	}
+}
+
+type speedSample struct {
+	Time  time.Time `json:"time"`
+	Speed float64   `json:"speed"`
+}
+
+// speedHandler returns speed over time for a time range, decimated down to at
+// most limit samples
+func speedHandler(db *sql.DB, limits queryLimits) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, limits)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(r, "limit", 1000)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limits.maxRows > 0 && limit > limits.maxRows {
+			http.Error(rw, fmt.Sprintf("limit can be at most %d", limits.maxRows), http.StatusBadRequest)
+			return
+		}
+		unit := r.URL.Query().Get("unit")
+		if _, err := convertSpeed(0, unit); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// keep every nth row so we return at most limit rows, 0 means keep everything
+		rows, err := db.QueryContext(r.Context(), `SELECT gps_timestamp, gps_speed FROM (
+			SELECT gps_timestamp, gps_speed, row_number() OVER (ORDER BY gps_timestamp) AS rn, count(*) OVER () AS n
+			FROM gps_logs WHERE gps_timestamp BETWEEN $1 AND $2
+		) s WHERE $3 = 0 OR (rn - 1) % GREATEST(1, CEIL(n::float / $3)::int) = 0 ORDER BY gps_timestamp`, from, to, limit)
+		if err != nil {
+			internalError(rw, fmt.Errorf("failed to query speed: %w", err))
+			return
+		}
+		defer rows.Close()
+
+		samples := make([]speedSample, 0)
+		for rows.Next() {
+			if limits.tooManyRows(rw, len(samples)+1) {
+				return
+			}
+			var sample speedSample
+			if err := rows.Scan(&sample.Time, &sample.Speed); err != nil {
+				internalError(rw, fmt.Errorf("failed to scan speed: %w", err))
+				return
+			}
+			// already validated
+			sample.Speed, _ = convertSpeed(sample.Speed, unit)
+			samples = append(samples, sample)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(rw, fmt.Errorf("failed to read speed: %w", err))
+			return
+		}
+
+		writeJSON(rw, samples)
+	}
+}
+
+type trip struct {
+	Trip   int       `json:"trip"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Points int       `json:"points"`
+}
+
+// tripsHandler lists trips in a time range. A new trip starts whenever
+// consecutive points are more than gap apart in time, or more than jump meters
+// apart in space (if jump is non-zero).
+func tripsHandler(db *sql.DB, limits queryLimits, gap time.Duration, jump float64) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, limits)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `SELECT trip, MIN(gps_timestamp), MAX(gps_timestamp), COUNT(*) FROM (
+			SELECT gps_timestamp, SUM(CASE
+				WHEN prev_timestamp IS NULL THEN 1
+				WHEN gps_timestamp - prev_timestamp > $3 * interval '1 second' THEN 1
+				WHEN $4 > 0 AND ST_Distance(gps_geometry, prev_geometry) > $4 THEN 1
+				ELSE 0
+			END) OVER (ORDER BY gps_timestamp) AS trip
+			FROM (
+				SELECT gps_timestamp, gps_geometry,
+					lag(gps_timestamp) OVER w AS prev_timestamp,
+					lag(gps_geometry) OVER w AS prev_geometry
+				FROM gps_logs WHERE gps_timestamp BETWEEN $1 AND $2
+				WINDOW w AS (ORDER BY gps_timestamp)
+			) l
+		) t GROUP BY trip ORDER BY trip`, from, to, gap.Seconds(), jump)
+		if err != nil {
+			internalError(rw, fmt.Errorf("failed to query trips: %w", err))
+			return
+		}
+		defer rows.Close()
+
+		trips := make([]trip, 0)
+		for rows.Next() {
+			if limits.tooManyRows(rw, len(trips)+1) {
+				return
+			}
+			var t trip
+			if err := rows.Scan(&t.Trip, &t.Start, &t.End, &t.Points); err != nil {
+				internalError(rw, fmt.Errorf("failed to scan trip: %w", err))
+				return
+			}
+			trips = append(trips, t)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(rw, fmt.Errorf("failed to read trips: %w", err))
+			return
+		}
+
+		writeJSON(rw, trips)
+	}
+}
+
+// notModified sets caching headers for a response that last changed at
+// modified, and reports whether the client's cached copy is still current, in
+// which case a 304 has been sent
+func notModified(rw http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	etag := fmt.Sprintf(`W/"%x"`, modified.UnixNano())
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	// If-None-Match takes precedence when both are sent
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, m := range strings.Split(match, ",") {
+			if m = strings.TrimSpace(m); m == etag || m == "*" {
+				rw.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(since) {
+		rw.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+type currentFix struct {
+	Time      time.Time `json:"time"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Altitude  *float64  `json:"altitude_m,omitempty"`
+	Speed     float64   `json:"speed_knots"`
+	Course    float64   `json:"course"`
+	// estimated from HDOP, nil without GSA
+	Accuracy *float64 `json:"accuracy_m,omitempty"`
+	// standard deviations from the receiver's own error estimate
+	Deviation *positionDeviation `json:"stddev_m,omitempty"`
+	// true heading from a compass, as opposed to the course over ground
+	Heading *float64 `json:"heading,omitempty"`
+	// the unsmoothed position, only set when smoothing
+	RawLatitude  *float64 `json:"raw_latitude,omitempty"`
+	RawLongitude *float64 `json:"raw_longitude,omitempty"`
+	// fix metadata, each left out without the sentence it comes from
+	FixQuality string   `json:"fix_quality,omitempty"`
+	Satellites *int64   `json:"satellites,omitempty"`
+	HDOP       *float64 `json:"hdop,omitempty"`
+	// GGA's geoid height above the ellipsoid, for outputs that want the
+	// altitude above the ellipsoid
+	geoidSeparation *float64
+}
+
+type currentResponse struct {
+	Updated time.Time `json:"updated"`
+	// nil without a fix
+	Fix *currentFix `json:"fix"`
+}
+
+// currentFixFrom returns the current fix, or nil without one. The caller must
+// hold data's lock.
+func currentFixFrom(data *MifiNMEAData, uere float64) *currentFix {
+	if data.RMC == nil {
+		return nil
+	}
+	// a bad date shouldn't hide the rest of the fix
+	t, _ := fixTime(data.RMC, data.ZDA)
+	fix := &currentFix{
+		Time:      t,
+		Latitude:  data.RMC.Latitude,
+		Longitude: data.RMC.Longitude,
+		Speed:     data.RMC.Speed,
+		Course:    data.RMC.Course,
+	}
+	if data.GGA != nil {
+		if altitude, err := altitudeMeters(data.GGA.Altitude, ggaAltitudeUnits(data.GGA)); err == nil {
+			fix.Altitude = &altitude
+		}
+		fix.FixQuality = data.GGA.FixQuality
+		satellites := data.GGA.NumSatellites
+		fix.Satellites = &satellites
+		fix.geoidSeparation = float64Ptr(data.GGA.Separation)
+	}
+	if hdop, ok := currentHDOP(data); ok {
+		fix.HDOP = &hdop
+	}
+	if data.GSA != nil {
+		if accuracy, ok := horizontalAccuracy(data.GSA.HDOP, uere); ok {
+			fix.Accuracy = &accuracy
+		}
+	}
+	if data.GST != nil {
+		fix.Deviation = gstDeviation(data.GST)
+	}
+	fix.Heading = data.TrueHeading()
+	if data.Smoothed != nil {
+		fix.RawLatitude = float64Ptr(fix.Latitude)
+		fix.RawLongitude = float64Ptr(fix.Longitude)
+		fix.Latitude = data.Smoothed.Latitude
+		fix.Longitude = data.Smoothed.Longitude
+		if fix.Altitude != nil && data.smoothedAltitude {
+			fix.Altitude = float64Ptr(data.Smoothed.Altitude)
+		}
+	}
+	return fix
+}
+
+// currentHandler returns the current position, or no fix once it's older than
+// stale_after
+func currentHandler(data *MifiNMEAData, uere float64, live *liveConfig) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		data.Lock()
+		defer data.Unlock()
+		fresh := data.FixFresh(live.Get().StaleAfter)
+		// going stale doesn't change Updated, so it can't be cached
+		if fresh && notModified(rw, r, data.Updated) {
+			return
+		}
+		res := currentResponse{Updated: data.Updated}
+		if fresh {
+			res.Fix = currentFixFrom(data, uere)
+		}
+		writeJSON(rw, res)
+	}
+}
+
+// recentHandler streams the most recent fixes as newline delimited JSON,
+// newest first
+func recentHandler(recent *fixRing) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		n, err := queryInt(r, "n", recent.Size())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// copied so a slow client doesn't hold the ring's lock
+		fixes := recent.Recent(n)
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := rw.(http.Flusher)
+		enc := json.NewEncoder(rw)
+		for _, fix := range fixes {
+			if err := enc.Encode(fix); err != nil {
+				log.Printf("error writing recent fixes: %s\n", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+type statusResponse struct {
+	// zero before any data
+	LastLine time.Time            `json:"last_line"`
+	LastFix  time.Time            `json:"last_fix"`
+	LastSeen map[string]time.Time `json:"last_seen"`
+	// kept across reconnects
+	CorruptSentences int         `json:"corrupt_sentences"`
+	SkippedSentences int         `json:"skipped_sentences"`
+	Stream           streamState `json:"stream"`
+}
+
+// statusHandler returns when data was last received from the GPS, to tell a
+// total feed loss from a partial one, and whether the stream is up
+func statusHandler(data *MifiNMEAData, stream *streamStatus) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		data.Lock()
+		res := statusResponse{
+			LastLine:         data.LastLine,
+			LastFix:          data.LastFix,
+			LastSeen:         map[string]time.Time{},
+			CorruptSentences: data.corruptSentences,
+			SkippedSentences: data.skippedSentences,
+			Stream:           stream.State(),
+		}
+		for sentence, t := range data.LastSeen {
+			res.LastSeen[sentence] = t
+		}
+		data.Unlock()
+		writeJSON(rw, res)
+	}
+}
+
+// debugSentence is a parsed sentence alongside the line it came from, with
+// Parsed nil when we haven't got one
+type debugSentence struct {
+	Parsed interface{} `json:"parsed"`
+	Raw    string      `json:"raw"`
+}
+
+type debugResponse struct {
+	Sentences map[string]debugSentence `json:"sentences"`
+	LastSeen  map[string]time.Time     `json:"last_seen"`
+	LastLine  time.Time                `json:"last_line"`
+	LastFix   time.Time                `json:"last_fix"`
+	Updated   time.Time                `json:"updated"`
+	Smoothed  *position                `json:"smoothed"`
+	// every satellite in view, put together from GSV cycles
+	Satellites []satelliteView `json:"satellites"`
+}
+
+// debugHandler returns everything we know about the GPS state, for diagnosing
+// odd parse results
+func debugHandler(data *MifiNMEAData) http.HandlerFunc {
+	// a nil pointer in an interface isn't encoded as null
+	sentence := func(parsed interface{}, isNil bool, raw string) debugSentence {
+		if isNil {
+			parsed = nil
+		}
+		return debugSentence{Parsed: parsed, Raw: raw}
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		data.Lock()
+		defer data.Unlock()
+		res := debugResponse{
+			Sentences: map[string]debugSentence{
+				"RMC": sentence(data.RMC, data.RMC == nil, data.RawRMC),
+				"GGA": sentence(data.GGA, data.GGA == nil, data.RawGGA),
+				"GSA": sentence(data.GSA, data.GSA == nil, data.RawGSA),
+				"GSV": sentence(data.GSV, data.GSV == nil, data.RawGSV),
+				"VTG": sentence(data.VTG, data.VTG == nil, data.RawVTG),
+				"GLL": sentence(data.GLL, data.GLL == nil, data.RawGLL),
+				"ZDA": sentence(data.ZDA, data.ZDA == nil, data.RawZDA),
+				"GNS": sentence(data.GNS, data.GNS == nil, data.RawGNS),
+				"GST": sentence(data.GST, data.GST == nil, data.RawGST),
+				"HDT": sentence(data.HDT, data.HDT == nil, data.RawHDT),
+				"THS": sentence(data.THS, data.THS == nil, data.RawTHS),
+			},
+			LastSeen: data.LastSeen,
+			LastLine: data.LastLine,
+			LastFix:  data.LastFix,
+			Updated:  data.Updated,
+			Smoothed: data.Smoothed,
+
+			Satellites: data.Sky.Views(),
+		}
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			internalError(rw, fmt.Errorf("failed to encode debug data: %w", err))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(append(b, '\n'))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+	data := &MifiNMEAData{corruptSentences: 2, skippedSentences: 3}
+	stream := &streamStatus{}
+	status := func() statusResponse {
+		rw := httptest.NewRecorder()
+		statusHandler(data, stream)(rw, httptest.NewRequest("GET", "/api/status", nil))
+		var res statusResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := status()
+	if res.CorruptSentences != 2 || res.SkippedSentences != 3 {
+		t.Errorf("expected 2 corrupt and 3 skipped sentences, got %d and %d", res.CorruptSentences, res.SkippedSentences)
+	}
+	if res.Stream.Up || res.Stream.Down {
+		t.Errorf("expected the stream to be neither up nor down before any data, got %+v", res.Stream)
+	}
+
+	stream.Up()
+	if res = status(); !res.Stream.Up || res.Stream.Down {
+		t.Errorf("expected the stream to be up, got %+v", res.Stream)
+	}
+
+	stream.Failed(errors.New("connection reset"))
+	stream.Failed(errors.New("connection refused"))
+	res = status()
+	if res.Stream.Up || !res.Stream.Down || res.Stream.DownSince == nil {
+		t.Errorf("expected the stream to be down, got %+v", res.Stream)
+	}
+	if res.Stream.Retries != 1 || res.Stream.LastError != "connection refused" {
+		t.Errorf("expected 1 retry failing with the last error, got %+v", res.Stream)
+	}
+}
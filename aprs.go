@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	aprsDialTimeout    = 10 * time.Second
+	aprsWriteTimeout   = 10 * time.Second
+	aprsReconnectDelay = time.Minute
+)
+
+// smart beaconing, beaconing more often the faster we go and when turning,
+// with the usual HamHUD defaults
+const (
+	// km/h
+	beaconSlowSpeed = 8
+	beaconFastSpeed = 100
+	beaconSlowRate  = 30 * time.Minute
+	beaconFastRate  = 3 * time.Minute
+	// degrees, and degrees times km/h, so slower turns need to be sharper
+	beaconTurnMinAngle = 28
+	beaconTurnSlope    = 42
+	beaconTurnMinTime  = 30 * time.Second
+)
+
+// smartBeacon decides when to beacon
+type smartBeacon struct {
+	sent       bool
+	last       time.Time
+	lastCourse float64
+}
+
+// Due reports whether a fix at speed km/h and course should be beaconed at
+// now. Only beacons recorded with Sent count.
+func (b *smartBeacon) Due(speed, course float64, now time.Time) bool {
+	elapsed := now.Sub(b.last)
+	due := !b.sent
+	switch {
+	case speed <= beaconSlowSpeed:
+		due = due || elapsed >= beaconSlowRate
+	case speed >= beaconFastSpeed:
+		due = due || elapsed >= beaconFastRate
+	default:
+		due = due || elapsed >= time.Duration(float64(beaconFastRate)*beaconFastSpeed/speed)
+	}
+	if speed > beaconSlowSpeed && elapsed >= beaconTurnMinTime {
+		turn := math.Abs(math.Mod(course-b.lastCourse+540, 360) - 180)
+		due = due || turn > beaconTurnMinAngle+beaconTurnSlope/speed
+	}
+	return due
+}
+
+// Sent records a beacon with course sent at now
+func (b *smartBeacon) Sent(now time.Time, course float64) {
+	b.sent = true
+	b.last = now
+	b.lastCourse = course
+}
+
+// aprsCoordinate formats degrees as APRS degrees and minutes, like 4903.50N
+func aprsCoordinate(degrees float64, width int, positive, negative byte) string {
+	hemisphere := positive
+	if degrees < 0 {
+		hemisphere = negative
+		degrees = -degrees
+	}
+	whole := math.Floor(degrees)
+	minutes := math.Round((degrees-whole)*60*100) / 100
+	if minutes >= 60 {
+		whole++
+		minutes -= 60
+	}
+	return fmt.Sprintf("%0*d%05.2f%c", width, int(whole), minutes, hemisphere)
+}
+
+// aprsPosition returns a position report for a fix, with course, speed and
+// altitude. symbol is the symbol table and code, like /> for a car.
+func aprsPosition(fix currentFix, callsign, symbol, comment string) string {
+	course := int(math.Round(fix.Course)) % 360
+	// 0 is unknown, north is 360
+	if course == 0 && fix.Speed >= minMovingSpeed {
+		course = 360
+	}
+	speed := int(math.Min(math.Round(fix.Speed), 999))
+	packet := fmt.Sprintf("%s>APRS,TCPIP*:!%s%c%s%c%03d/%03d",
+		callsign,
+		aprsCoordinate(fix.Latitude, 2, 'N', 'S'), symbol[0],
+		aprsCoordinate(fix.Longitude, 3, 'E', 'W'), symbol[1],
+		course, speed)
+	if fix.Altitude != nil {
+		packet += fmt.Sprintf("/A=%06d", int(math.Round(*fix.Altitude/feetToMeters)))
+	}
+	return packet + comment
+}
+
+// aprsClient beacons positions to APRS-IS at a smart beaconing rate. Beacons
+// are dropped while it's disconnected, and it reconnects in the background.
+type aprsClient struct {
+	server   string
+	callsign string
+	passcode string
+	symbol   string
+	comment  string
+
+	m      sync.Mutex
+	beacon smartBeacon
+
+	packets chan string
+}
+
+func newAPRSClient(server, callsign, passcode, symbol, comment string) *aprsClient {
+	c := &aprsClient{
+		server:   server,
+		callsign: callsign,
+		passcode: passcode,
+		symbol:   symbol,
+		comment:  comment,
+		packets:  make(chan string, 1),
+	}
+	go c.run()
+	return c
+}
+
+// Publish beacons a fix if it's due
+func (c *aprsClient) Publish(fix currentFix) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	now := time.Now()
+	if !c.beacon.Due(fix.Speed*knotsToKPH, fix.Course, now) {
+		return
+	}
+	select {
+	case c.packets <- aprsPosition(fix, c.callsign, c.symbol, c.comment):
+		c.beacon.Sent(now, fix.Course)
+	default:
+		// still sending the last one, try again with the next fix
+	}
+}
+
+func (c *aprsClient) run() {
+	for {
+		if err := c.connect(); err != nil {
+			log.Printf("error with APRS-IS: %v\n", err)
+		}
+		time.Sleep(aprsReconnectDelay)
+	}
+}
+
+// connect logs in to APRS-IS and sends beacons until the connection fails
+func (c *aprsClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.server, aprsDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "user %s pass %s vers mifi-gps 1.0\r\n", c.callsign, c.passcode); err != nil {
+		return err
+	}
+	// the server sends comments, like whether we're verified, and keepalives
+	closed := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "# logresp") {
+				log.Printf("APRS-IS: %s\n", strings.TrimPrefix(line, "# "))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			closed <- err
+			return
+		}
+		closed <- fmt.Errorf("server closed the connection")
+	}()
+	log.Printf("connected to APRS-IS %s\n", c.server)
+	for {
+		select {
+		case err := <-closed:
+			return err
+		case packet := <-c.packets:
+			if err := conn.SetWriteDeadline(time.Now().Add(aprsWriteTimeout)); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(conn, "%s\r\n", packet); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestAPRSBeaconDroppedWhileBusy(t *testing.T) {
+	c := &aprsClient{callsign: "N0CALL", symbol: "/>", packets: make(chan string, 1)}
+	// still sending an earlier packet
+	c.packets <- "earlier"
+	c.Publish(currentFix{Latitude: 48.1173, Longitude: 11.5167})
+	if c.beacon.sent {
+		t.Fatal("expected a dropped beacon not to count as sent")
+	}
+
+	<-c.packets
+	c.Publish(currentFix{Latitude: 48.1173, Longitude: 11.5167})
+	if !c.beacon.sent {
+		t.Error("expected the beacon to count as sent once queued")
+	}
+	if len(c.packets) != 1 {
+		t.Error("expected the beacon to be queued")
+	}
+}
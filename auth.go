@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireToken only allows requests with the API token, sent either as a
+// bearer token or as the basic auth password. Without a token configured the
+// handler is disabled entirely.
+func requireToken(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(rw, "forbidden, no API token is configured", http.StatusForbidden)
+			return
+		}
+		got := ""
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			got = strings.TrimPrefix(auth, "Bearer ")
+		} else if _, password, ok := r.BasicAuth(); ok {
+			got = password
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="mifi-gps"`)
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(rw, r)
+	}
+}
+
+// tokenFromQuery lets the API token be sent as the token query param, for
+// clients that can only be configured with a URL
+func tokenFromQuery(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		h(rw, r)
+	}
+}
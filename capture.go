@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// capture files are named after when they were started, so they sort in order
+const captureFileFormat = "nmea-20060102T150405Z.log"
+
+// rawCapture writes every raw line received to files in a directory, starting
+// a new file once the current one reaches maxSize bytes
+type rawCapture struct {
+	dir     string
+	maxSize int64
+	// how many files to keep, 0 keeps them all
+	keep int
+
+	m    sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRawCapture(dir string, maxSize int64, keep int) (*rawCapture, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create capture dir: %w", err)
+	}
+	return &rawCapture{dir: dir, maxSize: maxSize, keep: keep}, nil
+}
+
+// Write appends a line to the current capture file, NMEA style with CRLF
+func (c *rawCapture) Write(line []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.file == nil || c.size >= c.maxSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	// copied, so the caller's buffer isn't touched
+	n, err := c.file.Write(append(append([]byte(nil), line...), '\r', '\n'))
+	c.size += int64(n)
+	return err
+}
+
+func (c *rawCapture) rotate() error {
+	if c.file != nil {
+		if err := c.file.Close(); err != nil {
+			return err
+		}
+		c.file = nil
+	}
+	name := filepath.Join(c.dir, time.Now().UTC().Format(captureFileFormat))
+	// appending, in case we rotate twice in a second or restart into an existing file
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.file = f
+	c.size = info.Size()
+	return c.prune()
+}
+
+// prune deletes the oldest capture files beyond keep
+func (c *rawCapture) prune() error {
+	if c.keep <= 0 {
+		return nil
+	}
+	names, err := filepath.Glob(filepath.Join(c.dir, "nmea-*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > c.keep {
+		if err := os.Remove(names[0]); err != nil {
+			return fmt.Errorf("failed to delete old capture file: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// Close closes the current capture file
+func (c *rawCapture) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all settings. Each field tagged with `config:"name"` can be set,
+// in increasing order of precedence, in the config file as name, with the env
+// var MIFI_GPS_NAME (uppercased without underscores), or with the flag -name
+// (underscores replaced with dashes). Fields tagged `reload:"true"` can be
+// changed without restarting by sending SIGHUP.
+type Config struct {
+	NoDB       bool   `config:"no_db" usage:"run without a DB, reading GPS data and serving the web UI without storing anything"`
+	Storage    string `config:"storage" usage:"where to store logged positions, postgres or sqlite"`
+	DBConnStr  string `config:"db_conn_str" secret:"true" usage:"DB connection string, or the database file for sqlite"`
+	MapsAPIKey string `config:"maps_api_key" secret:"true" usage:"google static maps API key, required by the web UI"`
+
+	WebUI bool `config:"web_ui" usage:"serve the web UI and API on port 8080"`
+
+	Source     string `config:"source" reload:"true" usage:"where to read NMEA data from, mifi, gpsd, serial, udp, tcp or replay"`
+	Addr       string `config:"addr" reload:"true" usage:"address of the mifi's NMEA stream, a host:port, host or http URL"`
+	GPSDServer string `config:"gpsd_server" reload:"true" usage:"address of the gpsd to read from with the gpsd source"`
+
+	SerialDevice string `config:"serial_device" reload:"true" usage:"device to read NMEA from with the serial source"`
+	SerialBaud   int    `config:"serial_baud" reload:"true" usage:"baud rate of the serial device"`
+
+	UDPListen string `config:"udp_listen" reload:"true" usage:"address to listen for NMEA on with the udp source"`
+	TCPServer string `config:"tcp_server" reload:"true" usage:"address of the NMEA stream to read with the tcp source"`
+
+	ReplayFile  string  `config:"replay_file" reload:"true" usage:"file of NMEA sentences to read with the replay source"`
+	ReplaySpeed float64 `config:"replay_speed" reload:"true" usage:"how many times faster than real time to replay, 0 for as fast as possible"`
+
+	CaptureDir     string `config:"capture_dir" usage:"directory to save every raw NMEA line received to, for reprocessing later"`
+	CaptureMaxSize int    `config:"capture_max_size" usage:"size in MB at which to start a new capture file"`
+	CaptureKeep    int    `config:"capture_keep" usage:"how many capture files to keep, deleting the oldest, 0 keeps them all"`
+
+	ReadTimeout       time.Duration `config:"read_timeout" reload:"true" usage:"how long the GPS stream can go without data before reconnecting"`
+	Lenient           bool          `config:"lenient" reload:"true" usage:"skip sentences we don't support rather than reconnecting"`
+	StreamWatchdog    time.Duration `config:"stream_watchdog" reload:"true" usage:"how long the GPS stream can go without a sentence we can parse before reconnecting, 0 disables"`
+	DBMaxOpenConns    int           `config:"db_max_open_conns" usage:"maximum open DB connections"`
+	DBMaxIdleConns    int           `config:"db_max_idle_conns" usage:"maximum idle DB connections"`
+	DBConnMaxLifetime time.Duration `config:"db_conn_max_lifetime" usage:"how long a DB connection is reused before being recycled"`
+	FlushTimeout      time.Duration `config:"flush_timeout" usage:"how long a single push of queued data to the DB can take"`
+
+	QueueMax    int    `config:"queue_max" usage:"most logged positions to hold in memory while waiting to push them to the DB"`
+	QueuePolicy string `config:"queue_policy" usage:"what to do when the queue is full, drop-oldest, drop-newest or block"`
+	QueueFile   string `config:"queue_file" usage:"file to save queued positions to, so they survive restarts"`
+
+	OdometerFile string `config:"odometer_file" usage:"file to save the odometer to, so it survives restarts"`
+
+	FallbackFile  string        `config:"fallback_file" usage:"SQLite database to move queued positions to while the DB is unreachable"`
+	FallbackAfter time.Duration `config:"fallback_after" usage:"how long the DB can be unreachable before queued positions are moved to the fallback database"`
+
+	MaxHDOP    float64 `config:"max_hdop" reload:"true" usage:"skip logging fixes with a horizontal dilution of precision (from GSA, or GGA without it) above this, 0 disables"`
+	MaxPDOP    float64 `config:"max_pdop" reload:"true" usage:"skip logging fixes with a position dilution of precision (from GSA) above this, 0 disables"`
+	MaxSpeed   float64 `config:"max_speed" reload:"true" usage:"skip logging fixes that would mean moving faster than this many meters per second since the last logged fix, 0 disables"`
+	RequireGSA bool    `config:"require_gsa" reload:"true" usage:"skip logging when no GSA (DOP) data has been received"`
+
+	StationaryRadius    float64       `config:"stationary_radius" reload:"true" usage:"skip logging fixes within this many meters of the last logged fix, 0 disables"`
+	StationaryHeartbeat time.Duration `config:"stationary_heartbeat" reload:"true" usage:"when stationary, still log a fix this often"`
+
+	LogDistance       float64 `config:"log_distance" reload:"true" usage:"also log a location every this many meters traveled, 0 disables"`
+	AdaptiveIntervals string  `config:"adaptive_intervals" reload:"true" usage:"log more or less often by speed, as comma separated km/h:interval pairs like 80:30s,10:5m,0:30m, the fastest matching speed wins"`
+
+	FixQualities string `config:"fix_qualities" reload:"true" usage:"fix qualities to log, some of gps, dgps, pps, rtk, float-rtk, estimated, manual and simulated"`
+
+	Allow2D bool `config:"allow_2d" reload:"true" usage:"log a 2D position without altitude when there's no GGA data, the gps_geometry column must accept 2D points"`
+
+	UERE          float64 `config:"uere" usage:"receiver's user equivalent range error in meters, multiplied by HDOP to estimate accuracy"`
+	StoreAccuracy bool    `config:"store_accuracy" usage:"store the estimated accuracy in accuracy_m"`
+	StoreHeading  bool    `config:"store_heading" usage:"store the true heading from a compass (HDT or THS) in heading"`
+
+	SpeedSource string `config:"speed_source" reload:"true" usage:"where logged speed and course come from, rmc or vtg (falling back to rmc)"`
+
+	MapCenter  string        `config:"map_center" reload:"true" usage:"lat,lon to center the map on before there's a GPS fix"`
+	MapZoom    int           `config:"map_zoom" reload:"true" usage:"zoom level of the map shown before there's a GPS fix"`
+	StaleAfter time.Duration `config:"stale_after" reload:"true" usage:"how old the last fix, or GGA, can be before the web UI, API, gpsd clients and logger treat it as no fix, 0 disables"`
+
+	Smoothing       string  `config:"smoothing" usage:"smooth logged positions, either average or kalman"`
+	SmoothingWindow int     `config:"smoothing_window" usage:"number of fixes averaged by average smoothing"`
+	SmoothingNoise  float64 `config:"smoothing_noise" usage:"expected fix error in meters for kalman smoothing"`
+	SmoothingSpeed  float64 `config:"smoothing_speed" usage:"expected movement in meters per second for kalman smoothing"`
+	StoreRaw        bool    `config:"store_raw" usage:"when smoothing, also store the raw position"`
+
+	RecentFixes int `config:"recent_fixes" usage:"how many of the most recent fixes to keep in memory for /api/recent.ndjson"`
+
+	MaxQueryRange time.Duration `config:"max_query_range" usage:"longest time range the query APIs can be asked for, 0 for no limit"`
+	MaxQueryRows  int           `config:"max_query_rows" usage:"most rows the query APIs can read for one request, 0 for no limit"`
+
+	TripGap  time.Duration `config:"trip_gap" usage:"time between logged points that splits them into separate trips"`
+	TripJump float64       `config:"trip_jump" usage:"distance in meters between logged points that splits them into separate trips, 0 disables"`
+	TripStop time.Duration `config:"trip_stop" reload:"true" usage:"how long stopped before moving again starts a new trip, stored with logged points, 0 disables"`
+
+	GeofencesFile string `config:"geofences_file" usage:"YAML file of named circular or polygon geofences to report entering and leaving"`
+
+	SpeedLimit    float64       `config:"speed_limit" reload:"true" usage:"record an event when going faster than this in km/h for speed_limit_for, 0 disables"`
+	SpeedLimitFor time.Duration `config:"speed_limit_for" reload:"true" usage:"how long over speed_limit before recording an event"`
+
+	FixLostAfter time.Duration `config:"fix_lost_after" reload:"true" usage:"record an event when there's been no fix for this long, and when it's back, 0 disables"`
+
+	WebhookURLs       string `config:"webhook_urls" secret:"true" usage:"comma separated URLs to POST events to as JSON"`
+	WebhookDeadLetter string `config:"webhook_dead_letter" usage:"file to append events that couldn't be sent to webhooks to"`
+
+	LogInterval  time.Duration `config:"log_interval" reload:"true" usage:"how often to log a location, unless adaptive intervals or high resolution logging say otherwise"`
+	PushInterval time.Duration `config:"push_interval" reload:"true" usage:"how often queued locations are written to the DB"`
+
+	WarmupInterval time.Duration `config:"warmup_interval" usage:"how often to try logging at startup until the first location is logged, 0 disables"`
+
+	APIToken   string        `config:"api_token" secret:"true" usage:"token required by API endpoints that change things"`
+	HighResMax time.Duration `config:"high_res_max" reload:"true" usage:"longest that high resolution logging can be turned on for"`
+
+	Retention      time.Duration `config:"retention" reload:"true" usage:"delete logs older than this, 0 keeps everything"`
+	PruneInterval  time.Duration `config:"prune_interval" reload:"true" usage:"how often to delete old logs"`
+	PruneBatchSize int           `config:"prune_batch_size" reload:"true" usage:"how many logs to delete at a time"`
+
+	MQTTBroker   string `config:"mqtt_broker" usage:"MQTT broker to publish every fix to, like tcp://localhost:1883"`
+	MQTTTopic    string `config:"mqtt_topic" usage:"MQTT topic to publish fixes to"`
+	MQTTRetain   bool   `config:"mqtt_retain" usage:"publish fixes as retained messages so new subscribers get the last position"`
+	MQTTClientID string `config:"mqtt_client_id" usage:"MQTT client ID"`
+	MQTTUsername string `config:"mqtt_username" usage:"MQTT username"`
+	MQTTPassword string `config:"mqtt_password" secret:"true" usage:"MQTT password"`
+
+	MQTTDiscovery       bool   `config:"mqtt_discovery" usage:"publish Home Assistant MQTT discovery so the tracker shows up as a device_tracker"`
+	MQTTDiscoveryPrefix string `config:"mqtt_discovery_prefix" usage:"Home Assistant MQTT discovery prefix"`
+
+	OwntracksTopic    string        `config:"owntracks_topic" usage:"MQTT topic to publish OwnTracks locations to, like owntracks/user/device"`
+	OwntracksURL      string        `config:"owntracks_url" secret:"true" usage:"HTTP endpoint to POST OwnTracks locations to, like an OwnTracks Recorder's /pub"`
+	OwntracksTID      string        `config:"owntracks_tid" usage:"OwnTracks tracker ID shown on maps"`
+	OwntracksInterval time.Duration `config:"owntracks_interval" usage:"how often to send OwnTracks locations, 0 sends every fix"`
+
+	TraccarURL      string        `config:"traccar_url" secret:"true" usage:"Traccar server's OsmAnd protocol URL to forward fixes to, like http://traccar:5055"`
+	TraccarDeviceID string        `config:"traccar_device_id" usage:"device identifier registered in Traccar"`
+	TraccarInterval time.Duration `config:"traccar_interval" usage:"how often to forward fixes to Traccar, 0 sends every fix"`
+
+	APRSServer   string `config:"aprs_server" usage:"APRS-IS server to beacon positions to, like rotate.aprs2.net:14580"`
+	APRSCallsign string `config:"aprs_callsign" usage:"callsign and SSID to beacon as, like N0CALL-9"`
+	APRSPasscode string `config:"aprs_passcode" secret:"true" usage:"APRS-IS passcode for the callsign"`
+	APRSSymbol   string `config:"aprs_symbol" usage:"APRS symbol table and code, like /> for a car"`
+	APRSComment  string `config:"aprs_comment" usage:"comment to add to beacons"`
+
+	CoTAddr     string        `config:"cot_addr" usage:"where to send Cursor on Target position events for ATAK, like udp://239.2.3.1:6969 or tcp://takserver:8087"`
+	CoTUID      string        `config:"cot_uid" usage:"unique ID of CoT events"`
+	CoTCallsign string        `config:"cot_callsign" usage:"callsign shown on TAK maps"`
+	CoTType     string        `config:"cot_type" usage:"CoT event type, like a-f-G-E-V-C for a friendly civilian vehicle"`
+	CoTInterval time.Duration `config:"cot_interval" usage:"how often to send CoT events"`
+
+	GPSDAddr string `config:"gpsd_addr" usage:"address to serve the gpsd JSON protocol on"`
+
+	SignalKAddr string `config:"signalk_addr" usage:"address to stream Signal K deltas to TCP clients on"`
+
+	NMEAAddr string `config:"nmea_addr" usage:"address to re-serve valid NMEA sentences to TCP clients on, like 0.0.0.0:10110"`
+
+	NMEAUDPAddr  string `config:"nmea_udp_addr" usage:"UDP address to send valid NMEA sentences to, like 192.168.1.255:10110 to broadcast on the LAN"`
+	NMEAUDPRates string `config:"nmea_udp_rates" usage:"least time between UDP sentences of each type, like GSV:5s,*:1s"`
+}
+
+// where logged speed and course come from
+const (
+	speedSourceRMC = "rmc"
+	// VTG is often more reliable at low speeds
+	speedSourceVTG = "vtg"
+)
+
+func defaultConfig() Config {
+	return Config{
+		Storage:      storagePostgres,
+		WebUI:        true,
+		Source:       sourceMifi,
+		Addr:         defaultMifiAddr,
+		GPSDServer:   "127.0.0.1:" + defaultGPSDPort,
+		SerialDevice: "/dev/ttyUSB0",
+		// the NMEA 0183 standard rate
+		SerialBaud:     4800,
+		UDPListen:      ":" + defaultNMEAPort,
+		ReplaySpeed:    1,
+		CaptureMaxSize: 10,
+		ReadTimeout:    30 * time.Second,
+		StreamWatchdog: 2 * time.Minute,
+		// we're a single writer that flushes every few minutes, so keep the
+		// pool small and recycle connections so they don't go stale across db
+		// restarts
+		DBMaxOpenConns:    2,
+		DBMaxIdleConns:    1,
+		DBConnMaxLifetime: 30 * time.Minute,
+		FlushTimeout:      time.Minute,
+		QueueMax:          1000,
+		QueuePolicy:       queueDropOldest,
+		FallbackAfter:     30 * time.Minute,
+		FixQualities:      defaultFixQualities,
+		UERE:              5,
+		SpeedSource:       speedSourceRMC,
+		MapZoom:           10,
+		StaleAfter:        30 * time.Second,
+		SmoothingWindow:   5,
+		SmoothingNoise:    10,
+		SmoothingSpeed:    3,
+		RecentFixes:       100,
+		MaxQueryRange:     31 * 24 * time.Hour,
+		MaxQueryRows:      100000,
+		TripGap:           time.Hour,
+		LogInterval:       15 * time.Minute,
+		PushInterval:      5 * time.Minute,
+		WarmupInterval:    5 * time.Second,
+		HighResMax:        time.Hour,
+		PruneInterval:     24 * time.Hour,
+		PruneBatchSize:    1000,
+		MQTTTopic:         "mifi-gps/position",
+		MQTTRetain:        true,
+		MQTTClientID:      "mifi-gps",
+
+		// while parked, a "still here" point every hour
+		StationaryHeartbeat: time.Hour,
+		// brief bursts, like overtaking, don't count
+		SpeedLimitFor: 10 * time.Second,
+		// Home Assistant's default
+		MQTTDiscoveryPrefix: "homeassistant",
+		OwntracksTID:        "mg",
+		OwntracksInterval:   time.Minute,
+		APRSSymbol:          "/>",
+		CoTUID:              "mifi-gps",
+		CoTCallsign:         "mifi-gps",
+		CoTType:             "a-f-G-E-V-C",
+		CoTInterval:         10 * time.Second,
+	}
+}
+
+// configOption is a single settable field of Config
+type configOption struct {
+	name   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+func (o configOption) env() string {
+	return "MIFI_GPS_" + strings.ToUpper(strings.ReplaceAll(o.name, "_", ""))
+}
+
+func (o configOption) flag() string {
+	return strings.ReplaceAll(o.name, "_", "-")
+}
+
+// set parses s into the option's field
+func (o configOption) set(s string) error {
+	switch v := o.value.Addr().Interface().(type) {
+	case *string:
+		*v = s
+	case *int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		*v = i
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*v = f
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := parseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	default:
+		return fmt.Errorf("unsupported config type %s", o.value.Type())
+	}
+	return nil
+}
+
+func (c *Config) options() []configOption {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var options []configOption
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := f.Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+		options = append(options, configOption{
+			name:   name,
+			usage:  f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return options
+}
+
+// flagValue records a flag's raw value, so flags can be applied after the
+// config file and env vars
+type flagValue struct {
+	raw    string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.raw }
+func (f *flagValue) Set(s string) error { f.raw = s; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// loadConfig builds the config from, in increasing order of precedence,
+// defaults, a YAML config file (passed with -config), env vars, and flags
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	options := cfg.options()
+
+	fs := flag.NewFlagSet("mifi-gps", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("MIFI_GPS_CONFIG"), "path to a YAML config file (env MIFI_GPS_CONFIG)")
+	example := fs.Bool("example-config", false, "print an example config file with every setting and exit")
+	flags := map[string]*flagValue{}
+	for _, o := range options {
+		fv := &flagValue{isBool: o.value.Kind() == reflect.Bool}
+		flags[o.name] = fv
+		fs.Var(fv, o.flag(), fmt.Sprintf("%s (env %s)", o.usage, o.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if *example {
+		cfg.writeExample(os.Stdout)
+		os.Exit(0)
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath, options); err != nil {
+			return cfg, err
+		}
+	}
+
+	known := map[string]bool{"MIFI_GPS_CONFIG": true}
+	for _, o := range options {
+		known[o.env()] = true
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, "MIFI_GPS_") && !known[name] {
+			log.Printf("warning: ignoring unknown env var %s\n", name)
+		}
+	}
+	for _, o := range options {
+		if v := os.Getenv(o.env()); v != "" {
+			if err := o.set(v); err != nil {
+				return cfg, fmt.Errorf("invalid env var %s: %w", o.env(), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, o := range options {
+			if err == nil && o.flag() == f.Name {
+				if setErr := o.set(flags[o.name].raw); setErr != nil {
+					err = fmt.Errorf("invalid flag -%s: %w", f.Name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c *Config) loadFile(path string, options []configOption) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	known := map[string]bool{}
+	for _, o := range options {
+		known[o.name] = true
+	}
+	for name, node := range file {
+		if !known[name] {
+			return fmt.Errorf("unknown setting %q on line %d of config file", name, node.Line)
+		}
+	}
+	for _, o := range options {
+		node, ok := file[o.name]
+		if !ok {
+			continue
+		}
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("invalid %s in config file: expected a single value", o.name)
+		}
+		if err := o.set(node.Value); err != nil {
+			return fmt.Errorf("invalid %s in config file: %w", o.name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []string
+	switch c.Storage {
+	case storagePostgres:
+	case storageSQLite:
+		if c.FallbackFile != "" {
+			errs = append(errs, "fallback_file only works with postgres storage")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unknown storage %q, expected %s or %s", c.Storage, storagePostgres, storageSQLite))
+	}
+	if !c.NoDB && c.DBConnStr == "" {
+		errs = append(errs, "missing db connection string (db_conn_str)")
+	}
+	if c.WebUI && c.MapsAPIKey == "" {
+		errs = append(errs, "missing maps api key (maps_api_key), required by the web UI")
+	}
+	if c.UERE <= 0 {
+		errs = append(errs, "uere must be positive")
+	}
+	if c.SpeedSource != speedSourceRMC && c.SpeedSource != speedSourceVTG {
+		errs = append(errs, fmt.Sprintf("invalid speed_source %q, expected %s or %s", c.SpeedSource, speedSourceRMC, speedSourceVTG))
+	}
+	if _, err := mifiURL(c.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid addr: %s", err))
+	}
+	switch c.Source {
+	case sourceMifi, sourceGPSD, sourceUDP:
+	case sourceTCP:
+		if c.TCPServer == "" {
+			errs = append(errs, "missing tcp_server, required by the tcp source")
+		}
+	case sourceReplay:
+		if c.ReplayFile == "" {
+			errs = append(errs, "missing replay_file, required by the replay source")
+		}
+		if c.ReplaySpeed < 0 {
+			errs = append(errs, "replay_speed can't be negative")
+		}
+	case sourceSerial:
+		if _, ok := serialBaudRates[c.SerialBaud]; !ok {
+			errs = append(errs, fmt.Sprintf("unsupported serial_baud %d", c.SerialBaud))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unknown source %q, expected %s, %s, %s, %s, %s or %s", c.Source, sourceMifi, sourceGPSD, sourceSerial, sourceUDP, sourceTCP, sourceReplay))
+	}
+	if c.MaxHDOP < 0 || c.MaxPDOP < 0 {
+		errs = append(errs, "max_hdop and max_pdop can't be negative")
+	}
+	if c.MaxSpeed < 0 {
+		errs = append(errs, "max_speed can't be negative")
+	}
+	if c.LogInterval <= 0 || c.PushInterval <= 0 {
+		errs = append(errs, "log_interval and push_interval must be positive")
+	}
+	if c.FixLostAfter < 0 {
+		errs = append(errs, "fix_lost_after can't be negative")
+	}
+	if c.SpeedLimit < 0 || c.SpeedLimitFor < 0 {
+		errs = append(errs, "speed_limit and speed_limit_for can't be negative")
+	}
+	if c.TripStop < 0 {
+		errs = append(errs, "trip_stop can't be negative")
+	}
+	if c.LogDistance < 0 {
+		errs = append(errs, "log_distance can't be negative")
+	}
+	if _, err := parseAdaptiveIntervals(c.AdaptiveIntervals); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.StationaryRadius < 0 {
+		errs = append(errs, "stationary_radius can't be negative")
+	}
+	if c.StationaryHeartbeat <= 0 {
+		errs = append(errs, "stationary_heartbeat must be positive")
+	}
+	if c.CaptureMaxSize < 1 {
+		errs = append(errs, "capture_max_size must be at least 1")
+	}
+	if c.CaptureKeep < 0 {
+		errs = append(errs, "capture_keep can't be negative")
+	}
+	if _, err := parseFixQualities(c.FixQualities); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid fix_qualities: %s", err))
+	}
+	if c.MapCenter != "" {
+		if _, _, err := parseLatLon(c.MapCenter); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid map_center: %s", err))
+		}
+	}
+	if _, err := newSmoother(c.Smoothing, c.SmoothingWindow, c.SmoothingNoise, c.SmoothingSpeed); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid smoothing: %s", err))
+	}
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 {
+		errs = append(errs, "db connection limits can't be negative")
+	}
+	if c.RecentFixes < 0 {
+		errs = append(errs, "recent_fixes can't be negative")
+	}
+	if c.MaxQueryRows < 0 {
+		errs = append(errs, "max_query_rows can't be negative")
+	}
+	if c.QueueMax < 1 {
+		errs = append(errs, "queue_max must be at least 1")
+	}
+	if _, err := newFixQueue(c.QueueMax, c.QueuePolicy); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid queue_policy: %s", err))
+	}
+	if c.PruneBatchSize < 1 {
+		errs = append(errs, "prune_batch_size must be at least 1")
+	}
+	if c.MQTTDiscovery && c.MQTTBroker == "" {
+		errs = append(errs, "mqtt_discovery needs an mqtt_broker")
+	}
+	if _, err := url.Parse(c.TraccarURL); err != nil {
+		// the URL isn't shown, it can have credentials in it
+		errs = append(errs, "invalid traccar_url")
+	}
+	if _, err := parseNMEARates(c.NMEAUDPRates); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid nmea_udp_rates: %s", err))
+	}
+	if c.CoTAddr != "" {
+		if _, _, err := parseCoTAddr(c.CoTAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid cot_addr: %s", err))
+		}
+	}
+	if c.APRSServer != "" && (c.APRSCallsign == "" || c.APRSPasscode == "") {
+		errs = append(errs, "missing aprs_callsign or aprs_passcode, required by aprs_server")
+	}
+	if len(c.APRSSymbol) != 2 {
+		errs = append(errs, "aprs_symbol should be a symbol table and code, like />")
+	}
+	if c.TraccarURL != "" && c.TraccarDeviceID == "" {
+		errs = append(errs, "missing traccar_device_id, required by traccar_url")
+	}
+	if c.OwntracksTopic != "" && c.MQTTBroker == "" {
+		errs = append(errs, "owntracks_topic needs an mqtt_broker")
+	}
+	if c.MQTTDiscovery && c.MQTTDiscoveryPrefix == "" {
+		errs = append(errs, "missing mqtt_discovery_prefix, required by mqtt_discovery")
+	}
+	for _, o := range c.options() {
+		if d, ok := o.value.Interface().(time.Duration); ok && d < 0 {
+			errs = append(errs, fmt.Sprintf("%s can't be negative", o.name))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// logConfig logs the effective settings, without secrets
+func (c *Config) logConfig() {
+	for _, o := range c.options() {
+		v := fmt.Sprint(o.value.Interface())
+		if o.secret && v != "" {
+			v = "<redacted>"
+		}
+		log.Printf("config %s: %s\n", o.name, v)
+	}
+}
+
+// writeExample writes a config file with every setting commented out at its
+// default
+func (c *Config) writeExample(w io.Writer) {
+	fmt.Fprintln(w, "# mifi-gps config, settings can also be set with env vars or flags, which")
+	fmt.Fprintln(w, "# take precedence over this file")
+	for _, o := range c.options() {
+		v := fmt.Sprint(o.value.Interface())
+		if o.value.Kind() == reflect.String {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(w, "\n# %s\n# env %s, flag -%s\n#%s: %s\n", o.usage, o.env(), o.flag(), o.name, v)
+	}
+}
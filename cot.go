@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Cursor on Target (CoT) position events, so the tracker shows up on ATAK
+// and WinTAK maps
+
+const (
+	cotDialTimeout  = 10 * time.Second
+	cotWriteTimeout = 10 * time.Second
+	// how long TAK clients show a position once we stop sending
+	cotMinStale = 2 * time.Minute
+	// CoT's value for unknown altitude and errors
+	cotUnknown = 9999999.0
+)
+
+type cotEvent struct {
+	XMLName xml.Name  `xml:"event"`
+	Version string    `xml:"version,attr"`
+	UID     string    `xml:"uid,attr"`
+	Type    string    `xml:"type,attr"`
+	How     string    `xml:"how,attr"`
+	Time    string    `xml:"time,attr"`
+	Start   string    `xml:"start,attr"`
+	Stale   string    `xml:"stale,attr"`
+	Point   cotPoint  `xml:"point"`
+	Detail  cotDetail `xml:"detail"`
+}
+
+// cotFloat is written without an exponent, which TAK clients expect
+type cotFloat float64
+
+func (f cotFloat) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: strconv.FormatFloat(float64(f), 'f', -1, 64)}, nil
+}
+
+type cotPoint struct {
+	Lat cotFloat `xml:"lat,attr"`
+	Lon cotFloat `xml:"lon,attr"`
+	// meters above the ellipsoid
+	HAE cotFloat `xml:"hae,attr"`
+	// horizontal and vertical error in meters
+	CE cotFloat `xml:"ce,attr"`
+	LE cotFloat `xml:"le,attr"`
+}
+
+type cotDetail struct {
+	Contact struct {
+		Callsign string `xml:"callsign,attr"`
+	} `xml:"contact"`
+	Track struct {
+		Course cotFloat `xml:"course,attr"`
+		// m/s
+		Speed cotFloat `xml:"speed,attr"`
+	} `xml:"track"`
+	PrecisionLocation struct {
+		GeoPointSrc string `xml:"geopointsrc,attr"`
+		AltSrc      string `xml:"altsrc,attr"`
+	} `xml:"precisionlocation"`
+}
+
+// cotEventFrom returns a position event for a fix, shown on maps until stale
+func cotEventFrom(fix currentFix, uid, callsign, typ string, stale time.Duration) cotEvent {
+	const cotTime = "2006-01-02T15:04:05.000Z"
+	now := time.Now().UTC()
+	e := cotEvent{
+		Version: "2.0",
+		UID:     uid,
+		Type:    typ,
+		// machine generated, from a GPS
+		How:   "m-g",
+		Time:  now.Format(cotTime),
+		Start: now.Format(cotTime),
+		Stale: now.Add(stale).Format(cotTime),
+		Point: cotPoint{Lat: cotFloat(fix.Latitude), Lon: cotFloat(fix.Longitude), HAE: cotUnknown, CE: cotUnknown, LE: cotUnknown},
+	}
+	if fix.Altitude != nil {
+		e.Point.HAE = cotFloat(*fix.Altitude)
+		if fix.geoidSeparation != nil {
+			e.Point.HAE += cotFloat(*fix.geoidSeparation)
+		}
+	}
+	if fix.Accuracy != nil {
+		e.Point.CE = cotFloat(*fix.Accuracy)
+	}
+	e.Detail.Contact.Callsign = callsign
+	e.Detail.Track.Course = cotFloat(fix.Course)
+	e.Detail.Track.Speed = cotFloat(fix.Speed * knotsToMPS)
+	e.Detail.PrecisionLocation.GeoPointSrc = "GPS"
+	e.Detail.PrecisionLocation.AltSrc = "GPS"
+	if fix.Altitude == nil {
+		e.Detail.PrecisionLocation.AltSrc = "???"
+	}
+	return e
+}
+
+// parseCoTAddr parses where to send events, like udp://239.2.3.1:6969 for
+// ATAK's mesh or tcp://takserver:8087 for a TAK server
+func parseCoTAddr(s string) (network, addr string, err error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") {
+		return "", "", fmt.Errorf("expected udp:// or tcp://, got %q", s)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("missing host and port in %q", s)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// cotSender sends fixes as CoT events over UDP or TCP. TCP reconnects on the
+// next fix after failing.
+type cotSender struct {
+	throttledSender
+
+	network  string
+	addr     string
+	uid      string
+	callsign string
+	typ      string
+
+	conn net.Conn
+}
+
+func newCoTSender(network, addr, uid, callsign, typ string, interval time.Duration) *cotSender {
+	s := &cotSender{
+		throttledSender: newThrottledSender(interval),
+		network:         network,
+		addr:            addr,
+		uid:             uid,
+		callsign:        callsign,
+		typ:             typ,
+	}
+	go s.run()
+	return s
+}
+
+func (s *cotSender) run() {
+	stale := 3 * s.interval
+	if stale < cotMinStale {
+		stale = cotMinStale
+	}
+	for fix := range s.fixes {
+		payload, err := xml.Marshal(cotEventFrom(fix, s.uid, s.callsign, s.typ, stale))
+		if err != nil {
+			log.Printf("error encoding CoT event: %v\n", err)
+			continue
+		}
+		if err := s.send(append([]byte(xml.Header), payload...)); err != nil {
+			log.Printf("error sending CoT event: %v\n", err)
+		}
+	}
+}
+
+func (s *cotSender) send(payload []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, cotDialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(cotWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(payload); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import "github.com/adrianmo/go-nmea"
+
+// below this speed in knots, movement between fixes is taken to be GPS jitter
+// rather than travel
+const minMovingSpeed = 1
+
+// trackStep returns how far in meters was traveled between consecutive fixes
+func trackStep(from, to *nmea.RMC) float64 {
+	if to.Speed < minMovingSpeed {
+		return 0
+	}
+	return haversine(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+}
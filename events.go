@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	eventGeofenceEnter = "geofence_enter"
+	eventGeofenceExit  = "geofence_exit"
+	eventSpeedExceeded = "speed_exceeded"
+	eventFixLost       = "fix_lost"
+	eventFixRestored   = "fix_restored"
+	eventDBError       = "db_error"
+	eventDBRestored    = "db_restored"
+)
+
+// how many events are kept in memory for the web UI and API
+const recentEventsSize = 20
+
+// Event is something that happened, like crossing a geofence, that's recorded
+// and passed on to notifiers
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// what it's about, like a geofence's name
+	Name      string  `json:"name,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Message   string  `json:"message"`
+}
+
+// Notifier is told about events. It mustn't block, since events are emitted
+// while handling GPS data.
+type Notifier interface {
+	Notify(e Event)
+}
+
+// eventBus logs events, keeps the most recent ones and passes them on to
+// notifiers
+type eventBus struct {
+	m sync.Mutex
+
+	notifiers []Notifier
+	recent    []Event
+}
+
+// Subscribe adds a notifier for future events
+func (b *eventBus) Subscribe(n Notifier) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.notifiers = append(b.notifiers, n)
+}
+
+func (b *eventBus) Emit(e Event) {
+	log.Printf("event %s: %s\n", e.Type, e.Message)
+	b.m.Lock()
+	b.recent = append(b.recent, e)
+	if len(b.recent) > recentEventsSize {
+		b.recent = b.recent[len(b.recent)-recentEventsSize:]
+	}
+	notifiers := b.notifiers
+	b.m.Unlock()
+	for _, n := range notifiers {
+		n.Notify(e)
+	}
+}
+
+// Recent returns the most recent events, newest first
+func (b *eventBus) Recent() []Event {
+	b.m.Lock()
+	defer b.m.Unlock()
+	events := make([]Event, len(b.recent))
+	for i, e := range b.recent {
+		events[len(events)-1-i] = e
+	}
+	return events
+}
+
+// dbEventNotifier stores events in the events table. Events are written in the
+// background and dropped if the DB can't keep up.
+type dbEventNotifier struct {
+	db      *sql.DB
+	timeout time.Duration
+	events  chan Event
+}
+
+func newDBEventNotifier(db *sql.DB, timeout time.Duration) *dbEventNotifier {
+	n := &dbEventNotifier{db: db, timeout: timeout, events: make(chan Event, 100)}
+	go n.run()
+	return n
+}
+
+func (n *dbEventNotifier) Notify(e Event) {
+	select {
+	case n.events <- e:
+	default:
+		log.Printf("dropped %s event, too many waiting to be stored\n", e.Type)
+	}
+}
+
+func (n *dbEventNotifier) run() {
+	for e := range n.events {
+		if err := n.store(e); err != nil {
+			log.Printf("error storing event: %v\n", err)
+		}
+	}
+}
+
+func (n *dbEventNotifier) store(e Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	_, err := n.db.ExecContext(ctx, `INSERT INTO events (event_time, event_type, name, latitude, longitude, message) VALUES ($1, $2, $3, $4, $5, $6)`,
+		e.Time.UTC(), e.Type, e.Name, e.Latitude, e.Longitude, e.Message)
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+	return nil
+}
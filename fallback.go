@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// replay spooled fixes into Postgres this many at a time, so a long outage
+// doesn't become one huge transaction
+const fallbackReplayBatch = 1000
+
+// fallbackStorage stores fixes in Postgres, but once Postgres has been
+// unreachable for a while moves queued fixes into a local SQLite database
+// rather than holding them in memory. They're replayed into Postgres when
+// it's reachable again.
+type fallbackStorage struct {
+	primary *postgresStorage
+	local   *sql.DB
+	// how long flushes to Postgres can fail before spooling locally
+	after time.Duration
+
+	// flushes can be triggered manually too, don't let them overlap
+	flushMu sync.Mutex
+	// guarded by flushMu
+	failingSince time.Time
+
+	// how many fixes are in the local database
+	m       sync.Mutex
+	spooled int
+}
+
+func newFallbackStorage(primary *postgresStorage, local *sql.DB, after time.Duration) (*fallbackStorage, error) {
+	s := &fallbackStorage{primary: primary, local: local, after: after}
+	// left over from last time Postgres was down
+	if err := local.QueryRow(`SELECT count(*) FROM gps_logs`).Scan(&s.spooled); err != nil {
+		return nil, fmt.Errorf("failed to count fallback logs: %w", err)
+	}
+	return s, nil
+}
+
+func (s *fallbackStorage) Enqueue(ctx context.Context, fix Fix) (int, error) {
+	return s.primary.Enqueue(ctx, fix)
+}
+
+// Queued includes spooled fixes, since they're still waiting to be written
+// to Postgres
+func (s *fallbackStorage) Queued() (int, int) {
+	queued, dropped := s.primary.Queued()
+	s.m.Lock()
+	defer s.m.Unlock()
+	return queued + s.spooled, dropped
+}
+
+func (s *fallbackStorage) Flush(ctx context.Context) (int, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	n, err := s.primary.Flush(ctx)
+	if err != nil {
+		if s.failingSince.IsZero() {
+			s.failingSince = time.Now()
+		}
+		if time.Since(s.failingSince) >= s.after {
+			if spoolErr := s.spool(ctx); spoolErr != nil {
+				log.Printf("error writing to fallback DB: %v\n", spoolErr)
+			}
+		}
+		return 0, err
+	}
+	s.failingSince = time.Time{}
+	replayed, err := s.replay(ctx)
+	return n + replayed, err
+}
+
+// spool moves everything queued into the local database
+func (s *fallbackStorage) spool(ctx context.Context) error {
+	pending := s.primary.queue.Snapshot()
+	if len(pending.fixes) == 0 {
+		return nil
+	}
+	tx, err := s.local.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertSQLite(ctx, tx, pending.fixes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.primary.queue.Remove(pending)
+	s.m.Lock()
+	s.spooled += len(pending.fixes)
+	s.m.Unlock()
+	log.Printf("Postgres unreachable for %s, moved %d queued positions to the fallback DB\n", time.Since(s.failingSince).Round(time.Second), len(pending.fixes))
+	return nil
+}
+
+// replay writes spooled fixes to Postgres, returning how many were written
+func (s *fallbackStorage) replay(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		ids, fixes, err := selectSQLite(ctx, s.local, fallbackReplayBatch)
+		if err != nil {
+			return total, fmt.Errorf("failed to read fallback DB: %w", err)
+		}
+		if len(fixes) == 0 {
+			if total > 0 {
+				log.Printf("replayed %d positions from the fallback DB\n", total)
+			}
+			return total, nil
+		}
+		if err := s.primary.write(ctx, fixes); err != nil {
+			return total, err
+		}
+		// if this fails they'll be written again next time, which is better
+		// than losing them
+		if err := deleteSQLite(ctx, s.local, ids); err != nil {
+			return total, fmt.Errorf("failed to delete replayed logs from fallback DB: %w", err)
+		}
+		s.m.Lock()
+		s.spooled -= len(fixes)
+		s.m.Unlock()
+		total += len(fixes)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// why there's no fix
+const (
+	// the Mifi or receiver isn't sending anything, like when it's died
+	fixLostStreamDown = "the GPS stream is down"
+	// data is coming in without a fix, like in a parking garage
+	fixLostNoSatellites = "the receiver has no satellite fix"
+)
+
+// fixLossMonitor reports when there's been no valid fix for a while, and
+// when it's back
+type fixLossMonitor struct {
+	m sync.Mutex
+
+	started time.Time
+	lost    bool
+	cause   string
+	// while lost, the last fix, or when we started if there hadn't been one
+	since time.Time
+}
+
+func newFixLossMonitor() *fixLossMonitor {
+	return &fixLossMonitor{started: time.Now()}
+}
+
+// Check returns an event when there's been no fix for longer than after, or
+// when a fix is back after that. The stream is taken to be down when there's
+// been no data for readTimeout. last is the last fix, if any. An after of 0
+// disables the check.
+func (f *fixLossMonitor) Check(last *nmea.RMC, lastFix, lastLine time.Time, after, readTimeout time.Duration) *Event {
+	f.m.Lock()
+	defer f.m.Unlock()
+	now := time.Now()
+	since := lastFix
+	if since.IsZero() {
+		since = f.started
+	}
+	var e *Event
+	switch {
+	case after > 0 && now.Sub(since) > after:
+		if lastLine.IsZero() || now.Sub(lastLine) > readTimeout {
+			f.cause = fixLostStreamDown
+		} else {
+			f.cause = fixLostNoSatellites
+		}
+		if f.lost {
+			return nil
+		}
+		f.lost = true
+		f.since = since
+		e = &Event{Type: eventFixLost, Time: now, Message: fmt.Sprintf("no fix for %s, %s", now.Sub(since).Round(time.Second), f.cause)}
+	case f.lost:
+		f.lost = false
+		e = &Event{Type: eventFixRestored, Time: now, Message: fmt.Sprintf("fix is back after %s", since.Sub(f.since).Round(time.Second))}
+	default:
+		return nil
+	}
+	if last != nil {
+		e.Latitude, e.Longitude = last.Latitude, last.Longitude
+	}
+	return e
+}
+
+// Lost returns why there's no fix, or empty if there is one, and since when
+func (f *fixLossMonitor) Lost() (string, time.Time) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if !f.lost {
+		return "", time.Time{}
+	}
+	return f.cause, f.since
+}
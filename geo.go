@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const earthRadiusMeters = 6371008.8
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// haversine returns the great-circle distance in meters between two points
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// bearing returns the initial bearing in degrees true from one point to another
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	dLon := toRadians(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(toRadians(lat2))
+	x := math.Cos(toRadians(lat1))*math.Sin(toRadians(lat2)) - math.Sin(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// horizontalAccuracy estimates horizontal position error in meters from HDOP
+// and the receiver's user equivalent range error (UERE) in meters. It's not ok
+// unless both are positive, since receivers report an unknown HDOP as empty,
+// which parses as 0.
+func horizontalAccuracy(hdop, uere float64) (float64, bool) {
+	if hdop <= 0 || uere <= 0 {
+		return 0, false
+	}
+	return hdop * uere, true
+}
+
+const (
+	knotsToKPH = 1.852
+	knotsToMPH = 1.150779
+	knotsToMPS = 0.514444
+)
+
+// convertSpeed converts a speed in knots, as reported by NMEA, to the named unit
+func convertSpeed(knots float64, unit string) (float64, error) {
+	switch unit {
+	case "knots", "":
+		return knots, nil
+	case "kmh":
+		return knots * knotsToKPH, nil
+	case "mph":
+		return knots * knotsToMPH, nil
+	case "ms":
+		return knots * knotsToMPS, nil
+	default:
+		return 0, fmt.Errorf("unknown speed unit %q", unit)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHorizontalAccuracy(t *testing.T) {
+	tests := []struct {
+		name     string
+		hdop     float64
+		uere     float64
+		expected float64
+		ok       bool
+	}{
+		{"hdop times uere", 1.2, 5, 6, true},
+		{"fractional hdop", 0.8, 2.5, 2, true},
+		{"unknown hdop", 0, 5, 0, false},
+		{"negative hdop", -1, 5, 0, false},
+		{"zero uere", 1.2, 0, 0, false},
+		{"negative uere", 1.2, -5, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accuracy, ok := horizontalAccuracy(test.hdop, test.uere)
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+			if math.Abs(accuracy-test.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v", test.expected, accuracy)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// geofence is a named area, either a circle or a polygon
+type geofence struct {
+	Name string `yaml:"name"`
+	// a circle's center as latitude, longitude and its radius in meters
+	Center []float64 `yaml:"center"`
+	Radius float64   `yaml:"radius"`
+	// or a polygon's corners as latitude, longitude pairs
+	Polygon [][]float64 `yaml:"polygon"`
+}
+
+// loadGeofences reads a YAML list of geofences from a file
+func loadGeofences(file string) ([]geofence, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geofences: %w", err)
+	}
+	var fences []geofence
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fences); err != nil {
+		return nil, fmt.Errorf("failed to read geofences from %s: %w", file, err)
+	}
+	names := map[string]bool{}
+	for _, g := range fences {
+		if err := g.validate(); err != nil {
+			return nil, fmt.Errorf("invalid geofence %q in %s: %w", g.Name, file, err)
+		}
+		if names[g.Name] {
+			return nil, fmt.Errorf("duplicate geofence %q in %s", g.Name, file)
+		}
+		names[g.Name] = true
+	}
+	return fences, nil
+}
+
+func (g geofence) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	isCircle := g.Center != nil || g.Radius != 0
+	if isCircle == (g.Polygon != nil) {
+		return fmt.Errorf("expected either a center and radius or a polygon")
+	}
+	if isCircle {
+		if len(g.Center) != 2 {
+			return fmt.Errorf("center should be latitude, longitude")
+		}
+		if g.Radius <= 0 {
+			return fmt.Errorf("radius should be positive")
+		}
+		return nil
+	}
+	if len(g.Polygon) < 3 {
+		return fmt.Errorf("polygon needs at least 3 points")
+	}
+	for _, p := range g.Polygon {
+		if len(p) != 2 {
+			return fmt.Errorf("polygon points should be latitude, longitude")
+		}
+	}
+	return nil
+}
+
+// Contains reports whether a point is inside the geofence. Polygons are
+// treated as flat, which is fine at the size of a parking lot.
+func (g geofence) Contains(lat, lon float64) bool {
+	if g.Polygon == nil {
+		return haversine(g.Center[0], g.Center[1], lat, lon) <= g.Radius
+	}
+	// count crossings of a ray cast east from the point
+	inside := false
+	for i, j := 0, len(g.Polygon)-1; i < len(g.Polygon); j, i = i, i+1 {
+		a, b := g.Polygon[i], g.Polygon[j]
+		if (a[0] > lat) != (b[0] > lat) && lon < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// geofenceTracker follows which geofences we're in, to tell when they're
+// entered and left
+type geofenceTracker struct {
+	m sync.Mutex
+
+	fences []geofence
+	// nil until the first fix, which sets where we start without any events
+	inside map[string]bool
+}
+
+func newGeofenceTracker(fences []geofence) *geofenceTracker {
+	return &geofenceTracker{fences: fences}
+}
+
+// Update returns events for the geofences entered and left since the last
+// fix
+func (t *geofenceTracker) Update(lat, lon float64, at time.Time) []Event {
+	t.m.Lock()
+	defer t.m.Unlock()
+	first := t.inside == nil
+	if first {
+		t.inside = map[string]bool{}
+	}
+	var events []Event
+	for _, g := range t.fences {
+		inside := g.Contains(lat, lon)
+		if inside == t.inside[g.Name] {
+			continue
+		}
+		t.inside[g.Name] = inside
+		if first {
+			continue
+		}
+		e := Event{Type: eventGeofenceEnter, Time: at, Name: g.Name, Latitude: lat, Longitude: lon, Message: "entered " + g.Name}
+		if !inside {
+			e.Type = eventGeofenceExit
+			e.Message = "left " + g.Name
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Inside returns the names of the geofences we're in
+func (t *geofenceTracker) Inside() []string {
+	t.m.Lock()
+	defer t.m.Unlock()
+	var names []string
+	for _, g := range t.fences {
+		if t.inside[g.Name] {
+			names = append(names, g.Name)
+		}
+	}
+	return names
+}
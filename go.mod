@@ -7,3 +7,16 @@ require (
 	github.com/cridenour/go-postgis v1.0.0
 	github.com/lib/pq v1.10.6
 )
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/gorilla/websocket v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/sys v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+)
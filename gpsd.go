@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// a tiny subset of the gpsd JSON protocol (https://gpsd.gitlab.io/gpsd/gpsd_json.html),
+// enough for clients to ?WATCH our data as if we were gpsd
+
+const gpsdDevice = "mifi"
+
+type gpsdVersion struct {
+	Class      string `json:"class"`
+	Release    string `json:"release"`
+	Rev        string `json:"rev"`
+	ProtoMajor int    `json:"proto_major"`
+	ProtoMinor int    `json:"proto_minor"`
+}
+
+type gpsdDevices struct {
+	Class   string        `json:"class"`
+	Devices []gpsdDevInfo `json:"devices"`
+}
+
+type gpsdDevInfo struct {
+	Class  string `json:"class"`
+	Path   string `json:"path"`
+	Driver string `json:"driver"`
+}
+
+type gpsdWatch struct {
+	Class  string `json:"class"`
+	Enable bool   `json:"enable"`
+	JSON   bool   `json:"json"`
+}
+
+type gpsdTPV struct {
+	Class  string   `json:"class"`
+	Device string   `json:"device"`
+	Mode   int      `json:"mode"`
+	Time   string   `json:"time,omitempty"`
+	Lat    *float64 `json:"lat,omitempty"`
+	Lon    *float64 `json:"lon,omitempty"`
+	Alt    *float64 `json:"alt,omitempty"`
+	AltMSL *float64 `json:"altMSL,omitempty"`
+	Speed  *float64 `json:"speed,omitempty"`
+	Track  *float64 `json:"track,omitempty"`
+}
+
+type gpsdSatellite struct {
+	PRN  int64 `json:"PRN"`
+	El   int64 `json:"el"`
+	Az   int64 `json:"az"`
+	SS   int64 `json:"ss"`
+	Used bool  `json:"used"`
+}
+
+type gpsdSKY struct {
+	Class      string          `json:"class"`
+	Device     string          `json:"device"`
+	HDOP       *float64        `json:"hdop,omitempty"`
+	PDOP       *float64        `json:"pdop,omitempty"`
+	VDOP       *float64        `json:"vdop,omitempty"`
+	NSat       int             `json:"nSat"`
+	USat       int             `json:"uSat"`
+	Satellites []gpsdSatellite `json:"satellites"`
+}
+
+type gpsdPoll struct {
+	Class  string    `json:"class"`
+	Time   string    `json:"time"`
+	Active int       `json:"active"`
+	TPV    []gpsdTPV `json:"tpv"`
+	SKY    []gpsdSKY `json:"sky"`
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+// gpsdReports converts the current data to TPV and SKY reports, data must be
+// locked. A fix older than staleAfter is reported as no fix.
+func gpsdReports(data *MifiNMEAData, staleAfter time.Duration) (gpsdTPV, gpsdSKY) {
+	tpv := gpsdTPV{Class: "TPV", Device: gpsdDevice, Mode: 1}
+	fresh := data.FixFresh(staleAfter)
+	if fresh {
+		tpv.Mode = 2
+		if t, err := fixTime(data.RMC, data.ZDA); err == nil {
+			tpv.Time = t.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		tpv.Lat = float64Ptr(data.RMC.Latitude)
+		tpv.Lon = float64Ptr(data.RMC.Longitude)
+		tpv.Speed = float64Ptr(data.RMC.Speed * knotsToMPS)
+		tpv.Track = float64Ptr(data.RMC.Course)
+		if data.GGAFresh(staleAfter) {
+			if altitude, err := altitudeMeters(data.GGA.Altitude, ggaAltitudeUnits(data.GGA)); err == nil {
+				tpv.Mode = 3
+				tpv.Alt = float64Ptr(altitude)
+				tpv.AltMSL = float64Ptr(altitude)
+			}
+		}
+	}
+	if fresh && data.GSA != nil {
+		switch data.GSA.FixType {
+		case nmea.Fix2D:
+			tpv.Mode = 2
+		case nmea.FixNone:
+			tpv.Mode = 1
+		}
+	}
+
+	sky := gpsdSKY{Class: "SKY", Device: gpsdDevice, Satellites: []gpsdSatellite{}}
+	used := map[int64]bool{}
+	if data.GSA != nil {
+		sky.HDOP = float64Ptr(data.GSA.HDOP)
+		sky.PDOP = float64Ptr(data.GSA.PDOP)
+		sky.VDOP = float64Ptr(data.GSA.VDOP)
+		for _, sv := range data.GSA.SV {
+			if prn, err := strconv.ParseInt(sv, 10, 64); err == nil {
+				used[prn] = true
+			}
+		}
+	}
+	for _, view := range data.Sky.Views() {
+		for _, info := range view.Satellites {
+			sky.Satellites = append(sky.Satellites, gpsdSatellite{
+				PRN:  info.SVPRNNumber,
+				El:   info.Elevation,
+				Az:   info.Azimuth,
+				SS:   info.SNR,
+				Used: used[info.SVPRNNumber],
+			})
+		}
+	}
+	sky.NSat = len(sky.Satellites)
+	sky.USat = len(used)
+	return tpv, sky
+}
+
+// serveGPSD listens for gpsd clients on addr until ctx is done
+func serveGPSD(ctx context.Context, addr string, data *MifiNMEAData, live *liveConfig) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.Printf("serving gpsd protocol on %s\n", addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go handleGPSDConn(conn, data, live)
+	}
+}
+
+func handleGPSDConn(conn net.Conn, data *MifiNMEAData, live *liveConfig) {
+	defer conn.Close()
+
+	// read commands in the background so all writes happen in one place
+	commands := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(commands)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			// multiple commands can be sent on a line, each terminated with ';'
+			for _, cmd := range strings.Split(scanner.Text(), ";") {
+				if cmd = strings.TrimSpace(cmd); cmd == "" {
+					continue
+				}
+				select {
+				case commands <- cmd:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	enc := json.NewEncoder(conn)
+	send := func(v interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return enc.Encode(v) == nil
+	}
+	version := gpsdVersion{Class: "VERSION", Release: "3.25", Rev: "mifi-gps", ProtoMajor: 3, ProtoMinor: 14}
+	devices := gpsdDevices{Class: "DEVICES", Devices: []gpsdDevInfo{{Class: "DEVICE", Path: gpsdDevice, Driver: "NMEA0183"}}}
+	if !send(version) {
+		return
+	}
+
+	watching := false
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case cmd, ok := <-commands:
+			if !ok {
+				return
+			}
+			name, arg := cmd, ""
+			if i := strings.Index(cmd, "="); i >= 0 {
+				name, arg = cmd[:i], cmd[i+1:]
+			}
+			var reply []interface{}
+			switch name {
+			case "?VERSION":
+				reply = append(reply, version)
+			case "?DEVICES":
+				reply = append(reply, devices)
+			case "?WATCH":
+				watch := gpsdWatch{Class: "WATCH", Enable: true}
+				if arg != "" {
+					if err := json.Unmarshal([]byte(arg), &watch); err != nil {
+						reply = append(reply, map[string]string{"class": "ERROR", "message": "Invalid WATCH: " + err.Error()})
+						break
+					}
+				}
+				watching = watch.Enable
+				reply = append(reply, devices, watch)
+			case "?POLL":
+				data.Lock()
+				tpv, sky := gpsdReports(data, live.Get().StaleAfter)
+				data.Unlock()
+				reply = append(reply, gpsdPoll{
+					Class:  "POLL",
+					Time:   time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+					Active: 1,
+					TPV:    []gpsdTPV{tpv},
+					SKY:    []gpsdSKY{sky},
+				})
+			default:
+				reply = append(reply, map[string]string{"class": "ERROR", "message": fmt.Sprintf("Unrecognized request '%s'", name)})
+			}
+			for _, r := range reply {
+				if !send(r) {
+					return
+				}
+			}
+		case <-ticker.C:
+			if !watching {
+				continue
+			}
+			data.Lock()
+			tpv, sky := gpsdReports(data, live.Get().StaleAfter)
+			data.Unlock()
+			if !send(tpv) || !send(sky) {
+				return
+			}
+		}
+	}
+}
+
+// gpsd's standard port
+const defaultGPSDPort = "2947"
+
+// gpsdSource reads NMEA from a gpsd daemon, asking it to pass through the raw
+// sentences from its receivers rather than its JSON reports, so they're
+// handled just like the mifi's
+type gpsdSource struct {
+	addr string
+	// how long gpsd can go quiet before we consider it dead
+	readTimeout time.Duration
+}
+
+func (s *gpsdSource) String() string {
+	return "gpsd at " + s.addr
+}
+
+func (s *gpsdSource) Read(ctx context.Context, lines chan<- []byte) error {
+	dialer := &net.Dialer{Timeout: s.readTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(`?WATCH={"enable":true,"nmea":true};` + "\n")); err != nil {
+		return fmt.Errorf("failed to watch gpsd: %w", err)
+	}
+	// gpsd still sends some JSON, like its version, between sentences
+	return readConnLines(ctx, conn, s.readTimeout, lines, func(line []byte) bool {
+		return bytes.HasPrefix(line, []byte("{"))
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+func TestGPSDReportsStaleFix(t *testing.T) {
+	data := &MifiNMEAData{
+		RMC:      &nmea.RMC{Latitude: 48.1173, Longitude: 11.5167},
+		GGA:      &nmea.GGA{Altitude: 545.4},
+		LastFix:  time.Now(),
+		LastSeen: map[string]time.Time{nmea.TypeGGA: time.Now()},
+	}
+	if tpv, _ := gpsdReports(data, time.Minute); tpv.Mode != 3 || tpv.Lat == nil {
+		t.Errorf("expected a 3D fix, got %+v", tpv)
+	}
+
+	data.LastFix = time.Now().Add(-time.Hour)
+	data.LastSeen[nmea.TypeGGA] = time.Now().Add(-time.Hour)
+	if tpv, _ := gpsdReports(data, time.Minute); tpv.Mode != 1 || tpv.Lat != nil || tpv.Alt != nil {
+		t.Errorf("expected a stale fix to be reported as no fix, got %+v", tpv)
+	}
+	// 0 disables the age check
+	if tpv, _ := gpsdReports(data, 0); tpv.Mode != 3 {
+		t.Errorf("expected a 3D fix with the age check disabled, got %+v", tpv)
+	}
+}
+
+func TestServeGPSDStopsWhenCancelled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- serveGPSD(ctx, addr, &MifiNMEAData{}, newLiveConfig(defaultConfig(), nil))
+	}()
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected no error once cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("gpsd server didn't stop when cancelled")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/adrianmo/go-nmea"
+)
+
+// TypeGST is the type of GST sentences, which go-nmea doesn't support
+const TypeGST = "GST"
+
+// GST is GNSS Pseudorange Error Statistics, the receiver's own estimate of
+// its position error. Errors are standard deviations in meters, and are zero
+// when the receiver leaves them empty.
+// https://gpsd.gitlab.io/gpsd/NMEA.html#_gst_gps_pseudorange_noise_statistics
+//
+// Format: $--GST,hhmmss.ss,x.x,x.x,x.x,x.x,x.x,x.x,x.x*hh<CR><LF>
+// Example: $GPGST,172814.0,0.006,0.023,0.020,273.6,0.023,0.020,0.031*6A
+type GST struct {
+	nmea.BaseSentence
+	Time nmea.Time
+	// RMS of the pseudorange residuals
+	RMS float64
+	// the error ellipse, with its orientation in degrees from true north
+	MajorAxis   float64
+	MinorAxis   float64
+	Orientation float64
+
+	LatitudeError  float64
+	LongitudeError float64
+	AltitudeError  float64
+}
+
+func newGST(s nmea.BaseSentence) (nmea.Sentence, error) {
+	p := nmea.NewParser(s)
+	p.AssertType(TypeGST)
+	return GST{
+		BaseSentence:   s,
+		Time:           p.Time(0, "time"),
+		RMS:            p.Float64(1, "rms"),
+		MajorAxis:      p.Float64(2, "major axis"),
+		MinorAxis:      p.Float64(3, "minor axis"),
+		Orientation:    p.Float64(4, "orientation"),
+		LatitudeError:  p.Float64(5, "latitude error"),
+		LongitudeError: p.Float64(6, "longitude error"),
+		AltitudeError:  p.Float64(7, "altitude error"),
+	}, p.Err()
+}
+
+func init() {
+	if err := nmea.RegisterParser(TypeGST, newGST); err != nil {
+		panic(err)
+	}
+}
+
+// positionDeviation is the receiver's estimate of its position error, as
+// standard deviations in meters
+type positionDeviation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// gstDeviation returns the position error estimate from GST, or nil if the
+// receiver doesn't fill it in
+func gstDeviation(gst *GST) *positionDeviation {
+	if gst.LatitudeError == 0 && gst.LongitudeError == 0 {
+		return nil
+	}
+	return &positionDeviation{
+		Latitude:  gst.LatitudeError,
+		Longitude: gst.LongitudeError,
+		Altitude:  gst.AltitudeError,
+	}
+}
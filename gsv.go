@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// satelliteView is a complete set of satellites in view from one GSV cycle
+type satelliteView struct {
+	// like GP for GPS or GL for GLONASS
+	Talker     string         `json:"talker"`
+	SystemID   int64          `json:"system_id,omitempty"`
+	InView     int64          `json:"in_view"`
+	Satellites []nmea.GSVInfo `json:"satellites"`
+}
+
+// gsvAggregator puts together the numbered GSV messages of each cycle, which
+// only list four satellites each. Cycles are tracked per talker, and per
+// system for receivers that send them all with one talker.
+type gsvAggregator struct {
+	// cycles still being received
+	pending map[string]*satelliteView
+	// the last message number received for each pending cycle
+	last map[string]int64
+	// the latest complete cycles
+	complete map[string]satelliteView
+}
+
+// Add adds a GSV message, returning true if it completed a cycle. Messages
+// out of order start the cycle over.
+func (a *gsvAggregator) Add(m nmea.GSV) bool {
+	if a.pending == nil {
+		a.pending = map[string]*satelliteView{}
+		a.last = map[string]int64{}
+		a.complete = map[string]satelliteView{}
+	}
+	key := fmt.Sprintf("%s%d", m.Talker, m.SystemID)
+	view, ok := a.pending[key]
+	switch {
+	case m.MessageNumber == 1:
+		view = &satelliteView{Talker: m.Talker, SystemID: m.SystemID}
+		a.pending[key] = view
+	case !ok || m.MessageNumber != a.last[key]+1:
+		// missed part of the cycle, wait for the next one
+		delete(a.pending, key)
+		return false
+	}
+	a.last[key] = m.MessageNumber
+	view.InView = m.NumberSVsInView
+	view.Satellites = append(view.Satellites, m.Info...)
+	if m.MessageNumber < m.TotalMessages {
+		return false
+	}
+	a.complete[key] = *view
+	delete(a.pending, key)
+	return true
+}
+
+// Views returns the latest complete view from each talker, sorted by talker
+func (a gsvAggregator) Views() []satelliteView {
+	views := make([]satelliteView, 0, len(a.complete))
+	for _, view := range a.complete {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Talker != views[j].Talker {
+			return views[i].Talker < views[j].Talker
+		}
+		return views[i].SystemID < views[j].SystemID
+	})
+	return views
+}
+
+// InView returns the total number of satellites in view from every talker
+func (a gsvAggregator) InView() int64 {
+	var n int64
+	for _, view := range a.complete {
+		n += view.InView
+	}
+	return n
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// responses smaller than this aren't worth compressing
+const gzipMinSize = 1024
+
+// acceptsGzip reports whether the request allows a gzipped response
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(enc, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// it's big enough to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	// nil until we've decided, then either a gzip writer or the plain response
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= gzipMinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends headers and anything buffered so far, compressed or not
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	// the handler may have encoded the response itself
+	if compress && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush lets streaming handlers push data out as they go, which means
+// committing to compression before we know the size
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(w.status == 0 || w.status == http.StatusOK)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response, sending it uncompressed if it stayed small
+func (w *gzipResponseWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// nothing was written, let net/http send its default response
+			return nil
+		}
+		return w.decide(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// gzipHandler compresses responses for clients that accept gzip
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		// upgrades, like WebSockets, hijack the connection, which can't be
+		// compressed
+		if r.Method == http.MethodHead || !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: rw}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/adrianmo/go-nmea"
+)
+
+// TrueHeading returns the true heading from a compass, as opposed to the
+// course over ground, preferring THS, which says whether it's valid, over
+// HDT. It's nil without either. data must be locked.
+func (d *MifiNMEAData) TrueHeading() *float64 {
+	if d.THS != nil && d.THS.Status != nmea.InvalidTHS && len(d.THS.Fields) > 0 && d.THS.Fields[0] != "" {
+		heading := d.THS.Heading
+		return &heading
+	}
+	if d.HDT != nil && d.HDT.True && len(d.HDT.Fields) > 0 && d.HDT.Fields[0] != "" {
+		heading := d.HDT.Heading
+		return &heading
+	}
+	return nil
+}
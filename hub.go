@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// lineHub passes messages, like NMEA sentences, on to connected clients. Slow
+// clients miss messages rather than holding up the rest.
+type lineHub struct {
+	// how many messages each client can fall behind by
+	buffer int
+
+	m       sync.Mutex
+	clients map[chan []byte]bool
+}
+
+func newLineHub(buffer int) *lineHub {
+	return &lineHub{buffer: buffer, clients: map[chan []byte]bool{}}
+}
+
+// Clients returns how many clients are connected
+func (h *lineHub) Clients() int {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return len(h.clients)
+}
+
+// Send passes a message on to every client
+func (h *lineHub) Send(msg []byte) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	// the caller can reuse msg
+	msg = append([]byte(nil), msg...)
+	for c := range h.clients {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
+func (h *lineHub) subscribe() chan []byte {
+	h.m.Lock()
+	defer h.m.Unlock()
+	c := make(chan []byte, h.buffer)
+	h.clients[c] = true
+	return c
+}
+
+func (h *lineHub) unsubscribe(c chan []byte) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	delete(h.clients, c)
+}
+
+// serveLines streams a hub's messages to TCP clients on addr, each followed
+// by eol. what is what's served, for logs.
+func serveLines(addr, what, eol string, hub *lineHub) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving %s on %s\n", what, addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			msgs := hub.subscribe()
+			defer hub.unsubscribe(msgs)
+			for msg := range msgs {
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				// msg is shared with other clients, so it can't be appended to
+				if _, err := conn.Write([]byte(string(msg) + eol)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
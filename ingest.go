@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ingestValues are the fields of a posted position, by name
+type ingestValues map[string]string
+
+// get returns the first of names that's set
+func (v ingestValues) get(names ...string) (string, bool) {
+	for _, name := range names {
+		if s, ok := v[name]; ok && s != "" {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// float parses the first of names that's set, returning false if none are
+func (v ingestValues) float(names ...string) (float64, bool, error) {
+	s, ok := v.get(names...)
+	if !ok {
+		return 0, false, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false, fmt.Errorf("invalid %s %q", names[0], s)
+	}
+	return f, true, nil
+}
+
+// parseIngestTime parses a posted time, either unix seconds or milliseconds,
+// or RFC 3339
+func parseIngestTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		// anything this big is in milliseconds
+		if f > 1e12 {
+			f /= 1000
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected unix seconds or RFC 3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// readIngestValues reads a posted position from the query string, a form, or
+// a JSON object
+func readIngestValues(r *http.Request) (ingestValues, error) {
+	v := ingestValues{}
+	// only reads the body for forms
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("invalid form: %w", err)
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string]interface{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for name, value := range body {
+			switch value := value.(type) {
+			case string:
+				v[name] = value
+			case float64:
+				v[name] = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+	}
+	// query params and form fields override JSON
+	for name, values := range r.Form {
+		if len(values) > 0 {
+			v[name] = values[0]
+		}
+	}
+	return v, nil
+}
+
+// ingestFix builds a fix from a position posted by an app like GPSLogger or
+// OsmAnd. Speed is in meters per second, like those apps send.
+func ingestFix(v ingestValues, uere float64, now time.Time) (Fix, error) {
+	fix := Fix{LoggedAt: now, Time: now}
+	lat, ok, err := v.float("lat", "latitude")
+	if err != nil {
+		return Fix{}, err
+	}
+	if !ok {
+		return Fix{}, errors.New("missing lat")
+	}
+	lon, ok, err := v.float("lon", "lng", "longitude")
+	if err != nil {
+		return Fix{}, err
+	}
+	if !ok {
+		return Fix{}, errors.New("missing lon")
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return Fix{}, fmt.Errorf("invalid position %f,%f", lat, lon)
+	}
+	fix.Position = position{Latitude: lat, Longitude: lon}
+	if alt, ok, err := v.float("alt", "altitude"); err != nil {
+		return Fix{}, err
+	} else if ok {
+		fix.Position.Altitude = alt
+		fix.HasAltitude = true
+	}
+	if speed, ok, err := v.float("speed", "spd"); err != nil {
+		return Fix{}, err
+	} else if ok {
+		fix.Speed = speed / knotsToMPS
+	}
+	if course, ok, err := v.float("bearing", "course", "dir"); err != nil {
+		return Fix{}, err
+	} else if ok {
+		fix.Course = course
+	}
+	if accuracy, ok, err := v.float("accuracy", "acc"); err != nil {
+		return Fix{}, err
+	} else if ok {
+		fix.Accuracy = &accuracy
+	} else if hdop, ok, err := v.float("hdop"); err != nil {
+		return Fix{}, err
+	} else if ok {
+		if accuracy, ok := horizontalAccuracy(hdop, uere); ok {
+			fix.Accuracy = &accuracy
+		}
+	}
+	if s, ok := v.get("timestamp", "time"); ok {
+		t, err := parseIngestTime(s)
+		if err != nil {
+			return Fix{}, err
+		}
+		fix.Time = t
+	}
+	return fix, nil
+}
+
+// ingestHandler accepts positions posted by phone apps and queues them to be
+// stored like our own fixes
+func ingestHandler(queueFix func(ctx context.Context, fix Fix) error, uere float64) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		// some apps can only be configured with a URL
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := readIngestValues(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fix, err := ingestFix(v, uere, time.Now())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := queueFix(r.Context(), fix); err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			internalError(rw, fmt.Errorf("failed to queue posted position: %w", err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
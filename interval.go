@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// speedInterval logs every interval at or above a speed in km/h
+type speedInterval struct {
+	speed    float64
+	interval time.Duration
+}
+
+// adaptiveIntervals picks how often to log by speed, fastest first
+type adaptiveIntervals []speedInterval
+
+// parseAdaptiveIntervals parses comma separated speed:interval pairs, like
+// "80:30s,10:5m,0:30m", with speeds in km/h. Empty disables adaptive
+// intervals.
+func parseAdaptiveIntervals(s string) (adaptiveIntervals, error) {
+	var intervals adaptiveIntervals
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid adaptive interval %q, expected speed:interval", pair)
+		}
+		speed, err := strconv.ParseFloat(strings.TrimSpace(pair[:i]), 64)
+		if err != nil || speed < 0 {
+			return nil, fmt.Errorf("invalid speed in adaptive interval %q", pair)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(pair[i+1:]))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in adaptive interval %q", pair)
+		}
+		intervals = append(intervals, speedInterval{speed: speed, interval: interval})
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].speed > intervals[j].speed
+	})
+	return intervals, nil
+}
+
+// For returns how often to log at a speed in knots, or fallback if it's
+// slower than every speed given
+func (a adaptiveIntervals) For(knots float64, fallback time.Duration) time.Duration {
+	kph := knots * knotsToKPH
+	for _, i := range a {
+		if kph >= i.speed {
+			return i.interval
+		}
+	}
+	return fallback
+}
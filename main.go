@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
@@ -9,39 +8,246 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/adrianmo/go-nmea"
 	_ "github.com/lib/pq"
 )
 
-type Http0_9ConnWrapper struct {
-	net.Conn
-	haveReadAny bool
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
-func (c *Http0_9ConnWrapper) Read(b []byte) (int, error) {
-	if c.haveReadAny {
-		return c.Conn.Read(b)
+// splitSentences splits a line into the NMEA sentences it contains, since
+// some sources pack several onto one line. Anything before the first $ is
+// noise and dropped.
+func splitSentences(line []byte) [][]byte {
+	var sentences [][]byte
+	start := bytes.IndexByte(line, '$')
+	for start >= 0 {
+		end := bytes.IndexByte(line[start+1:], '$')
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start + 1
+		}
+		if sentence := bytes.TrimSpace(line[start:end]); len(sentence) > 1 {
+			sentences = append(sentences, sentence)
+		}
+		if end == len(line) {
+			break
+		}
+		start = end
 	}
-	c.haveReadAny = true
-	// fake an http 1.1 connection to make the default go http client happier
-	response := []byte("HTTP/1.1 200 OK\r\nConnection: keep-alive\r\nContent-Type: text/plain\r\n\r\n")
-	copy(b, response)
-	return len(response), nil
+	return sentences
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+// vtgSpeedCourse returns the ground speed in knots and true course from a
+// VTG sentence, which leaves them empty when it doesn't have them
+func vtgSpeedCourse(vtg *nmea.VTG) (speed, course float64, ok bool) {
+	if len(vtg.Fields) < 5 || vtg.Fields[0] == "" || vtg.Fields[4] == "" {
+		return 0, 0, false
 	}
-	return b
+	return vtg.GroundSpeedKnots, vtg.TrueTrack, true
+}
+
+// timeOfDay returns how far into the day an NMEA time is, to the millisecond
+func timeOfDay(t nmea.Time) time.Duration {
+	return time.Duration(t.Hour)*time.Hour + time.Duration(t.Minute)*time.Minute + time.Duration(t.Second)*time.Second + time.Duration(t.Millisecond)*time.Millisecond
+}
+
+// nmeaTime combines an NMEA date and time, which are always UTC
+func nmeaTime(year, month, day int, t nmea.Time) (time.Time, error) {
+	if !t.Valid {
+		return time.Time{}, errors.New("missing time")
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalizes out of range values rather than rejecting them
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %04d-%02d-%02d", year, month, day)
+	}
+	if t.Hour > 23 || t.Minute > 59 || t.Second > 60 {
+		return time.Time{}, fmt.Errorf("invalid time %s", t)
+	}
+	return date.Add(timeOfDay(t)), nil
+}
+
+// rmcTime returns the time of an RMC fix
+func rmcTime(rmc *nmea.RMC) (time.Time, error) {
+	if !rmc.Date.Valid {
+		return time.Time{}, errors.New("failed to read RMC date time: missing date")
+	}
+	// two digit years, like Go's time parsing
+	year := 2000 + rmc.Date.YY
+	if rmc.Date.YY >= 69 {
+		year = 1900 + rmc.Date.YY
+	}
+	t, err := nmeaTime(year, rmc.Date.MM, rmc.Date.DD, rmc.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read RMC date time: %w", err)
+	}
+	return t, nil
+}
+
+// zdaTime returns the time from a ZDA sentence, which has a four digit year
+func zdaTime(zda *nmea.ZDA) (time.Time, error) {
+	t, err := nmeaTime(int(zda.Year), int(zda.Month), int(zda.Day), zda.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read ZDA date time: %w", err)
+	}
+	return t, nil
+}
+
+// fixTime returns the time of a fix, preferring the date from ZDA when it's
+// from the same second as the RMC, as RMC only has a two digit year
+func fixTime(rmc *nmea.RMC, zda *nmea.ZDA) (time.Time, error) {
+	if zda != nil && zda.Time == rmc.Time {
+		if t, err := zdaTime(zda); err == nil {
+			return t, nil
+		}
+	}
+	return rmcTime(rmc)
+}
+
+// gllRMC builds an RMC fix from a GLL sentence, for receivers that don't send
+// RMC. GLL has no date, so it's taken from now, allowing for a fix from just
+// before midnight, and speed and course come from VTG if we have it.
+func gllRMC(gll *nmea.GLL, vtg *nmea.VTG, now time.Time) nmea.RMC {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), gll.Time.Hour, gll.Time.Minute, gll.Time.Second, 0, time.UTC)
+	if t.Sub(now) > 12*time.Hour {
+		t = t.AddDate(0, 0, -1)
+	}
+	m := nmea.RMC{
+		Time:      gll.Time,
+		Validity:  nmea.ValidRMC,
+		Latitude:  gll.Latitude,
+		Longitude: gll.Longitude,
+		Date:      nmea.Date{Valid: true, DD: t.Day(), MM: int(t.Month()), YY: t.Year() % 100},
+	}
+	if vtg != nil {
+		if speed, course, ok := vtgSpeedCourse(vtg); ok {
+			m.Speed, m.Course = speed, course
+		}
+	}
+	return m
+}
+
+const feetToMeters = 0.3048
+
+// altitudeMeters converts an NMEA altitude to meters based on its units field
+func altitudeMeters(altitude float64, units string) (float64, error) {
+	switch units {
+	// most receivers always use meters, treat missing units the same way
+	case "M", "":
+		return altitude, nil
+	case "F":
+		return altitude * feetToMeters, nil
+	default:
+		return 0, fmt.Errorf("unexpected altitude units %q", units)
+	}
+}
+
+// GNS modes as GGA fix qualities, in increasing order of precision
+var gnsQualities = []struct{ modes, quality string }{
+	{nmea.EstimatedGNS, nmea.EST},
+	{nmea.AutonomousGNS, nmea.GPS},
+	{nmea.DifferentialGNS + nmea.PreciseGNS, nmea.DGPS},
+	{nmea.FloatRTKGNS, nmea.FRTK},
+	{nmea.RealTimeKinematicGNS, nmea.RTK},
+}
+
+// currentHDOP returns the HDOP from GSA, or from GGA for receivers that don't
+// send GSA. data must be locked.
+func currentHDOP(data *MifiNMEAData) (float64, bool) {
+	if data.GSA != nil {
+		return data.GSA.HDOP, true
+	}
+	// an empty HDOP parses as 0
+	if data.GGA != nil && data.GGA.HDOP > 0 {
+		return data.GGA.HDOP, true
+	}
+	return 0, false
+}
+
+// gnsGGA builds GGA fix data from a GNS sentence, sent instead of GGA by
+// multi-constellation receivers, returning false if no constellation has a fix
+func gnsGGA(gns *nmea.GNS) (nmea.GGA, bool) {
+	// the best fix of any constellation
+	quality := nmea.Invalid
+	for _, q := range gnsQualities {
+		for _, mode := range gns.Mode {
+			if strings.Contains(q.modes, mode) {
+				quality = q.quality
+			}
+		}
+	}
+	if quality == nmea.Invalid {
+		return nmea.GGA{FixQuality: nmea.Invalid}, false
+	}
+	return nmea.GGA{
+		Time:          gns.Time,
+		Latitude:      gns.Latitude,
+		Longitude:     gns.Longitude,
+		FixQuality:    quality,
+		NumSatellites: gns.SVs,
+		HDOP:          gns.HDOP,
+		// always meters
+		Altitude:   gns.Altitude,
+		Separation: gns.Separation,
+	}, true
+}
+
+// ggaAltitudeUnits returns the altitude units field, which nmea.GGA doesn't parse
+func ggaAltitudeUnits(gga *nmea.GGA) string {
+	if len(gga.Fields) <= 9 {
+		return ""
+	}
+	return gga.Fields[9]
+}
+
+// parseLatLon parses a "lat,lon" pair
+func parseLatLon(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected \"lat,lon\", got %q", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude: %w", err)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude: %w", err)
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("coordinates out of range: %q", s)
+	}
+	return lat, lon, nil
+}
+
+// parseDuration is like time.ParseDuration, but also accepts a whole number of
+// days, like "90d"
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
 }
 
 type MifiNMEAData struct {
@@ -50,8 +256,71 @@ type MifiNMEAData struct {
 	RMC *nmea.RMC
 	GGA *nmea.GGA
 	GSA *nmea.GSA
+	// the validity of the latest RMC and fix quality of the latest GGA, even
+	// when they aren't valid fixes, for checking whether the fix was lost
+	RMCValidity string
+	GGAQuality  string
+
+	// the last GSV message received, only listing some of the satellites
 	GSV *nmea.GSV
 	VTG *nmea.VTG
+	// only used for the position when there's no RMC
+	GLL *nmea.GLL
+	// date and time, with a four digit year
+	ZDA *nmea.ZDA
+	// only used for GGA data when there's no GGA
+	GNS *nmea.GNS
+	// the receiver's error estimates
+	GST *GST
+	// true heading from a compass
+	HDT *nmea.HDT
+	THS *nmea.THS
+
+	// the raw sentences each of the above were parsed from
+	RawRMC string
+	RawGGA string
+	RawGSA string
+	RawGSV string
+	RawVTG string
+	RawGLL string
+	RawZDA string
+	RawGNS string
+	RawGST string
+	RawHDT string
+	RawTHS string
+
+	// every satellite in view, put together from each GSV cycle
+	Sky gsvAggregator
+
+	// when each sentence type was last received, since some receivers stop
+	// sending some types while still sending others
+	LastSeen map[string]time.Time
+
+	// when we last got a valid RMC fix, kept across Clear so we can tell how
+	// stale the data is
+	LastFix time.Time
+	// when we last got any line from the GPS stream, kept across Clear
+	LastLine time.Time
+	// when the position data (RMC/GGA) last changed, for caching
+	Updated time.Time
+
+	// smoothed position, only set if smoothing is enabled
+	Smoothed *position
+	// whether Smoothed has an altitude, which it doesn't while GGA is stale
+	smoothedAltitude bool
+	smoother         smoother
+
+	// meters traveled since the last logged location, for distance based
+	// logging, kept across Clear
+	sinceLogged float64
+
+	// proprietary sentences we've logged that we're ignoring, kept across Clear
+	ignoredProprietary map[string]bool
+
+	// sentences that were corrupt, and unsupported sentences skipped in
+	// lenient mode, kept across Clear
+	corruptSentences int
+	skippedSentences int
 
 	m sync.Mutex
 }
@@ -70,13 +339,87 @@ func (d *MifiNMEAData) Clear() {
 	d.GGA = nil
 	d.GSA = nil
 	d.GSV = nil
+	d.Sky = gsvAggregator{}
+	d.RMCValidity = ""
+	d.GGAQuality = ""
 	d.VTG = nil
+	d.GLL = nil
+	d.ZDA = nil
+	d.GNS = nil
+	d.GST = nil
+	d.HDT = nil
+	d.THS = nil
+	d.RawRMC = ""
+	d.RawGGA = ""
+	d.RawGSA = ""
+	d.RawGSV = ""
+	d.RawVTG = ""
+	d.RawGLL = ""
+	d.RawZDA = ""
+	d.RawGNS = ""
+	d.RawGST = ""
+	d.RawHDT = ""
+	d.RawTHS = ""
+	d.LastSeen = nil
+	d.Updated = time.Now()
+	d.Smoothed = nil
+	d.smoothedAltitude = false
+	if d.smoother != nil {
+		d.smoother.Reset()
+	}
 	d.Unlock()
 }
 
+// FixFresh reports whether there's a fix newer than maxAge, so a stream that's
+// dropped silently doesn't leave its last position looking current. 0 disables
+// the age check. d must be locked.
+func (d *MifiNMEAData) FixFresh(maxAge time.Duration) bool {
+	return d.RMC != nil && (maxAge <= 0 || time.Since(d.LastFix) <= maxAge)
+}
+
+// GGAFresh reports whether there's GGA data, or GNS standing in for it, newer
+// than maxAge. 0 disables the age check. d must be locked.
+func (d *MifiNMEAData) GGAFresh(maxAge time.Duration) bool {
+	if d.GGA == nil {
+		return false
+	}
+	if maxAge <= 0 {
+		return true
+	}
+	for _, sentence := range []string{nmea.TypeGGA, nmea.TypeGNS} {
+		if seen, ok := d.LastSeen[sentence]; ok && time.Since(seen) <= maxAge {
+			return true
+		}
+	}
+	return false
+}
+
 var funcMap = template.FuncMap{
 	"gps": nmea.FormatGPS,
 	"dms": nmea.FormatDMS,
+	"now": time.Now,
+	"since": func(t time.Time) time.Duration {
+		return time.Since(t).Round(time.Second)
+	},
+	"fixtype": func(fixType string) string {
+		switch fixType {
+		case nmea.FixNone:
+			return "no fix"
+		case nmea.Fix2D:
+			return "2D"
+		case nmea.Fix3D:
+			return "3D"
+		default:
+			return fixType
+		}
+	},
+	"snr": func(snr int64) string {
+		// satellites that aren't being tracked report a null (zero) SNR
+		if snr == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d dB", snr)
+	},
 }
 
 //go:embed index.html
@@ -84,242 +427,974 @@ var rawIndexTemplate string
 var indexTemplate = template.Must(template.New("index.html").Funcs(funcMap).Parse(rawIndexTemplate))
 
 type templateData struct {
-	MapsAPIKey         string
-	Data               *MifiNMEAData
-	QueueLen           int
+	MapsAPIKey   string
+	Data         *MifiNMEAData
+	QueueLen     int
+	QueueDropped int
+	QueuePolicy  string
+	// false when running without a DB
+	Persistence        bool
 	LastSuccessfulPush time.Time
 	LastAttemptedPush  time.Time
+	RejectedByDOP      int
+	RejectedByQuality  int
+	RejectedOutliers   int
+	Odometer           odometerReading
+	Events             []Event
+	Geofences          []string
+	FixLost            string
+	FixLostSince       time.Time
+	CorruptSentences   int
+	SkippedSentences   int
+	Lenient            bool
+	DefaultMapCenter   string
+	DefaultMapZoom     int
+	StaleAfter         time.Duration
+	HighResUntil       time.Time
 }
 
+// Stale reports whether we haven't had a fix recently enough to trust the
+// displayed position
+func (t templateData) Stale() bool {
+	return t.Data == nil || !t.Data.FixFresh(t.StaleAfter)
+}
+
+// HasFix reports whether there's a live position to show and center maps on,
+// otherwise the default center is used
+func (t templateData) HasFix() bool {
+	return !t.Stale()
+}
+
+// Position returns the position to show, smoothed when smoothing is enabled
+func (t templateData) Position() position {
+	if t.Data.Smoothed != nil {
+		return *t.Data.Smoothed
+	}
+	return position{Latitude: t.Data.RMC.Latitude, Longitude: t.Data.RMC.Longitude}
+}
+
+// HasGGA reports whether there's recent enough GGA data to show
+func (t templateData) HasGGA() bool {
+	return t.Data != nil && t.Data.GGAFresh(t.StaleAfter)
+}
+
+// flush early once this much is queued
+const flushBatchSize = 100
+
+// how long to wait for in-flight web requests when shutting down
+const shutdownTimeout = 5 * time.Second
+
 var ErrNoDataToLog = fmt.Errorf("no data to log")
+var ErrDOPTooHigh = fmt.Errorf("dilution of precision too high")
+var ErrFixQuality = fmt.Errorf("fix quality too low")
 
-type queuedOp struct {
-	query string
-	args  []interface{}
+// skippedFix reports whether an error from logging a fix means there wasn't
+// one worth logging, rather than that something went wrong
+func skippedFix(err error) bool {
+	return errors.Is(err, ErrNoDataToLog) || errors.Is(err, ErrDOPTooHigh) || errors.Is(err, ErrFixQuality) || errors.Is(err, ErrOutlier) || errors.Is(err, ErrStationary)
 }
 
+// ErrCorruptSentence is returned for lines that fail the checksum or can't be
+// parsed, as opposed to valid sentences we don't support
+var ErrCorruptSentence = fmt.Errorf("corrupt nmea sentence")
+
+// ErrUnsupportedSentence is returned for valid sentences we don't support,
+// which are only skipped in lenient mode
+var ErrUnsupportedSentence = fmt.Errorf("unsupported nmea sentence")
+
 func main() {
-	connStr := os.Getenv("MIFI_GPS_DBCONNSTR")
-	if connStr == "" {
-		panic("missing db connection string in env var MIFI_GPS_DBCONNSTR")
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := runSimulate(os.Args[2:]); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	cfg.logConfig()
+	// settings that can be reloaded are read from here when they're used
+	live := newLiveConfig(cfg, os.Args[1:])
+
+	connStr := cfg.DBConnStr
+	mapsAPIKey := cfg.MapsAPIKey
+
+	dbMaxOpenConns := cfg.DBMaxOpenConns
+	dbMaxIdleConns := cfg.DBMaxIdleConns
+	dbConnMaxLifetime := cfg.DBConnMaxLifetime
+	flushTimeout := cfg.FlushTimeout
+
+	// optionally smooth out jitter in logged positions
+	smoothing, err := newSmoother(cfg.Smoothing, cfg.SmoothingWindow, cfg.SmoothingNoise, cfg.SmoothingSpeed)
+	if err != nil {
+		log.Fatalf("invalid smoothing config: %s\n", err)
+	}
+	// when smoothing, also store the raw position
+	storeRawPosition := cfg.StoreRaw
+	// store an estimate of horizontal accuracy derived from HDOP
+	storeAccuracy := cfg.StoreAccuracy
+	// store the compass heading, when there is one
+	storeHeading := cfg.StoreHeading
+
+	// how far apart consecutive points can be before they're considered separate trips
+	tripGap := cfg.TripGap
+	tripJump := cfg.TripJump
+
+	// required for endpoints that change things
+	apiToken := cfg.APIToken
+
+	// how often to try logging until the first location is logged, 0 disables
+	warmupInterval := cfg.WarmupInterval
+
+	// optionally act like gpsd so gpsd clients can use our data
+	gpsdAddr := cfg.GPSDAddr
+
+	// cancelled on SIGINT or SIGTERM, stopping everything so queued data can
+	// be pushed before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down, interrupt again to quit immediately")
+		// a second signal kills us straight away
+		stop()
+	}()
+
+	data := &MifiNMEAData{smoother: smoothing, Updated: time.Now()}
+	// the last few fixes, for clients that don't want to query the db
+	recent := newFixRing(cfg.RecentFixes)
+	// optionally keep a lossless record of everything received
+	var capture *rawCapture
+	if cfg.CaptureDir != "" {
+		capture, err = newRawCapture(cfg.CaptureDir, int64(cfg.CaptureMaxSize)*1024*1024, cfg.CaptureKeep)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+	// reads the configured source into data, started once everything it feeds
+	// is set up
+	reader := newNMEAReader(data, live, capture)
+
+	// optionally publish every fix to MQTT
+	var mqttPub *mqttPublisher
+	if cfg.MQTTBroker != "" {
+		discoveryPrefix := ""
+		if cfg.MQTTDiscovery {
+			discoveryPrefix = cfg.MQTTDiscoveryPrefix
+		}
+		mqttPub = newMQTTPublisher(cfg.MQTTBroker, cfg.MQTTClientID, cfg.MQTTUsername, cfg.MQTTPassword, cfg.MQTTTopic, cfg.MQTTRetain, discoveryPrefix)
+	}
+	var owntracks *owntracksPublisher
+	if cfg.OwntracksTopic != "" || cfg.OwntracksURL != "" {
+		owntracks = newOwntracksPublisher(cfg.OwntracksTID, cfg.OwntracksInterval, cfg.OwntracksTopic, mqttPub, cfg.OwntracksURL)
+	}
+	var traccar *traccarForwarder
+	if cfg.TraccarURL != "" {
+		traccar = newTraccarForwarder(cfg.TraccarURL, cfg.TraccarDeviceID, cfg.TraccarInterval)
+	}
+	var cot *cotSender
+	if cfg.CoTAddr != "" {
+		// already validated
+		network, addr, _ := parseCoTAddr(cfg.CoTAddr)
+		cot = newCoTSender(network, addr, cfg.CoTUID, cfg.CoTCallsign, cfg.CoTType, cfg.CoTInterval)
+	}
+	// Signal K clients connect to the web server or signalk_addr
+	signalK := newSignalKHub()
+	// valid sentences are passed on to nmea_addr's clients, sentences come
+	// in bursts, so they can fall further behind
+	nmeaOut := newLineHub(64)
+	var nmeaUDP *nmeaUDPSender
+	if cfg.NMEAUDPAddr != "" {
+		// already validated
+		rates, _ := parseNMEARates(cfg.NMEAUDPRates)
+		nmeaUDP, err = newNMEAUDPSender(cfg.NMEAUDPAddr, rates)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+	}
+	var aprs *aprsClient
+	if cfg.APRSServer != "" {
+		aprs = newAPRSClient(cfg.APRSServer, cfg.APRSCallsign, cfg.APRSPasscode, cfg.APRSSymbol, cfg.APRSComment)
 	}
 
-	mapsAPIKey := os.Getenv("MIFI_GPS_MAPSAPIKEY")
-	if connStr == "" {
-		panic("missing maps api key in env var MIFI_GPS_MAPSAPIKEY")
+	// things that happen, like crossing geofences, for notifiers
+	events := &eventBus{}
+	fixLoss := newFixLossMonitor()
+	var fences *geofenceTracker
+	if cfg.GeofencesFile != "" {
+		geofences, err := loadGeofences(cfg.GeofencesFile)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		log.Printf("loaded %d geofences from %s\n", len(geofences), cfg.GeofencesFile)
+		fences = newGeofenceTracker(geofences)
 	}
 
-	data := &MifiNMEAData{}
-	queue := make([]queuedOp, 0)
+	queue, err := newFixQueue(cfg.QueueMax, cfg.QueuePolicy)
+	if err != nil {
+		log.Fatalf("invalid queue config: %s\n", err)
+	}
+	if cfg.QueueFile != "" {
+		n, err := queue.Persist(cfg.QueueFile)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		log.Printf("saving queued positions to %s, loaded %d\n", cfg.QueueFile, n)
+	}
+	odo, err := newOdometer(cfg.OdometerFile)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	// flush early when logging quickly, or before a small queue fills up
+	flushAt := flushBatchSize
+	if cfg.QueueMax < flushAt {
+		flushAt = cfg.QueueMax
+	}
+	// guarded by pushStatusMu
+	var pushStatusMu sync.Mutex
 	var lastSuccessfulPush time.Time
 	var lastAttemptedPush time.Time
 
+	// guarded by data's lock
+	var rejectedByDOP int
+	var rejectedByQuality int
+	// rejects logged fixes that jump impossibly far
+	outliers := &outlierFilter{}
+	// skips logging while parked
+	stationary := &stationaryFilter{}
+	// groups logged points into trips
+	trips := &tripTracker{}
+	// guarded by data's lock
+	speeding := &speedMonitor{}
+	// log every fix until this time
+	var highResUntil time.Time
+
+	// signalled on every new RMC fix
+	newFix := make(chan struct{}, 1)
+	// signalled when there's enough queued to flush early
+	flushNow := make(chan struct{}, 1)
+
 	var wg sync.WaitGroup
 
+	// where positions are stored, nil without a DB
+	var storeDB *sql.DB
+	// Postgres, needed by the query APIs, nil otherwise
+	var db *sql.DB
+	var storage Storage = discardStorage{}
+	switch {
+	case cfg.NoDB:
+		log.Println("running without a DB, logged positions won't be stored")
+	case cfg.Storage == storageSQLite:
+		storeDB, err = openSQLite(connStr)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		log.Println("storing positions in SQLite, the elevation, speed and trips APIs are disabled")
+		storage = newSQLiteStorage(storeDB, queue, flushTimeout, storeRawPosition, storeAccuracy, storeHeading)
+	default:
+		db, err = sql.Open("postgres", connStr)
+		if err != nil {
+			panic(err)
+		}
+		db.SetMaxOpenConns(dbMaxOpenConns)
+		db.SetMaxIdleConns(dbMaxIdleConns)
+		db.SetConnMaxLifetime(dbConnMaxLifetime)
+		log.Printf("opened DB connection (max open: %d, max idle: %d, max lifetime: %s)\n", dbMaxOpenConns, dbMaxIdleConns, dbConnMaxLifetime)
+		storeDB = db
+		pg := newPostgresStorage(db, queue, flushTimeout, storeRawPosition, storeAccuracy, storeHeading)
+		storage = pg
+		if cfg.FallbackFile != "" {
+			fallbackDB, err := openSQLite(cfg.FallbackFile)
+			if err != nil {
+				log.Fatalf("%s\n", err)
+			}
+			defer fallbackDB.Close()
+			fallback, err := newFallbackStorage(pg, fallbackDB, cfg.FallbackAfter)
+			if err != nil {
+				log.Fatalf("%s\n", err)
+			}
+			log.Printf("falling back to %s when the DB is unreachable for %s\n", cfg.FallbackFile, cfg.FallbackAfter)
+			storage = fallback
+		}
+	}
+	if storeDB != nil {
+		events.Subscribe(newDBEventNotifier(storeDB, flushTimeout))
+	}
+	if cfg.WebhookURLs != "" {
+		events.Subscribe(newWebhookNotifier(cfg.WebhookURLs, cfg.WebhookDeadLetter))
+	}
+
+	http.HandleFunc("/api/current", currentHandler(data, cfg.UERE, live))
+	// the name people look for first
+	http.HandleFunc("/api/position", currentHandler(data, cfg.UERE, live))
+	http.HandleFunc("/nmea", nmeaHandler(data))
+	http.HandleFunc("/api/status", statusHandler(data, reader.stream))
+	http.HandleFunc("/api/recent.ndjson", recentHandler(recent))
+	http.HandleFunc("/signalk", signalKDiscoveryHandler)
+	http.HandleFunc("/signalk/v1/stream", signalKStreamHandler(signalK))
+	http.HandleFunc("/api/events", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, events.Recent())
+	})
+	http.HandleFunc("/debug", requireToken(apiToken, debugHandler(data)))
+	http.HandleFunc("/api/highres", requireToken(apiToken, func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		duration, err := parseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration < 0 {
+			http.Error(rw, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		// longest that high resolution logging can be turned on for at once
+		if highResMax := live.Get().HighResMax; duration > highResMax {
+			http.Error(rw, fmt.Sprintf("duration can be at most %s", highResMax), http.StatusBadRequest)
+			return
+		}
+		data.Lock()
+		highResUntil = time.Now().Add(duration)
+		res := struct {
+			Until time.Time `json:"until"`
+		}{highResUntil}
+		data.Unlock()
+		log.Printf("high resolution logging for %s\n", duration)
+		writeJSON(rw, res)
+	}))
+	http.HandleFunc("/api/odometer", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, odo.Reading())
+	})
+	http.HandleFunc("/api/odometer/reset", requireToken(apiToken, func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("reset trip odometer")
+		writeJSON(rw, odo.ResetTrip())
+	}))
+	if db != nil {
+		limits := queryLimits{maxRange: cfg.MaxQueryRange, maxRows: cfg.MaxQueryRows}
+		http.HandleFunc("/api/elevation", elevationHandler(db, limits))
+		http.HandleFunc("/api/speed", speedHandler(db, limits))
+		http.HandleFunc("/api/trips", tripsHandler(db, limits, tripGap, tripJump))
+	}
 	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		pushStatusMu.Lock()
+		successfulPush := lastSuccessfulPush
+		attemptedPush := lastAttemptedPush
+		pushStatusMu.Unlock()
+		queueLen, queueDropped := storage.Queued()
+		c := live.Get()
+		// where to point the map before we've got a fix, already validated
+		var defaultMapCenter string
+		if c.MapCenter != "" {
+			lat, lon, _ := parseLatLon(c.MapCenter)
+			defaultMapCenter = fmt.Sprintf("%f,%f", lat, lon)
+		}
+		var inside []string
+		if fences != nil {
+			inside = fences.Inside()
+		}
+		fixLost, fixLostSince := fixLoss.Lost()
 		data.Lock()
 		defer data.Unlock()
 		if err := indexTemplate.Execute(rw, templateData{
 			MapsAPIKey:         mapsAPIKey,
 			Data:               data,
-			QueueLen:           len(queue),
-			LastSuccessfulPush: lastSuccessfulPush,
-			LastAttemptedPush:  lastAttemptedPush,
+			QueueLen:           queueLen,
+			QueueDropped:       queueDropped,
+			QueuePolicy:        cfg.QueuePolicy,
+			Persistence:        storeDB != nil,
+			LastSuccessfulPush: successfulPush,
+			LastAttemptedPush:  attemptedPush,
+			RejectedByDOP:      rejectedByDOP,
+			RejectedByQuality:  rejectedByQuality,
+			RejectedOutliers:   outliers.Rejected(),
+			Odometer:           odo.Reading(),
+			Events:             events.Recent(),
+			Geofences:          inside,
+			FixLost:            fixLost,
+			FixLostSince:       fixLostSince,
+			CorruptSentences:   data.corruptSentences,
+			SkippedSentences:   data.skippedSentences,
+			Lenient:            c.Lenient,
+			DefaultMapCenter:   defaultMapCenter,
+			DefaultMapZoom:     c.MapZoom,
+			StaleAfter:         c.StaleAfter,
+			HighResUntil:       highResUntil,
 		}); err != nil {
 			log.Printf("error rendering web page: %s\n", err)
 		}
 	})
+	if cfg.WebUI {
+		server := &http.Server{Addr: "0.0.0.0:8080", Handler: gzipHandler(http.DefaultServeMux)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Println("starting web UI")
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("error shutting down web UI: %v\n", err)
+			}
+		}()
+	} else {
+		log.Println("web UI disabled")
+	}
+
+	if storeDB != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c := live.Get()
+				// 0 keeps everything
+				if c.Retention > 0 {
+					n, err := pruneLogs(ctx, storeDB, time.Now().Add(-c.Retention), c.PruneBatchSize)
+					if err != nil {
+						log.Printf("error pruning old logs: %v\n", err)
+					} else {
+						log.Printf("pruned %d logs older than %s\n", n, c.Retention)
+					}
+				}
+				select {
+				case <-time.After(c.PruneInterval):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// watch for losing the fix, telling a dead stream from no satellites
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Println("starting web UI")
-		err := http.ListenAndServe("0.0.0.0:8080", nil)
-		if err != nil {
-			panic(err)
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			c := live.Get()
+			// replays end, and their fixes aren't live anyway
+			if c.Source == sourceReplay {
+				continue
+			}
+			data.Lock()
+			e := fixLoss.Check(data.RMC, data.LastFix, data.LastLine, c.FixLostAfter, c.ReadTimeout)
+			data.Unlock()
+			if e != nil {
+				events.Emit(*e)
+			}
 		}
 	}()
 
-	queueLocation := func() error {
+	if gpsdAddr != "" {
+		go func() {
+			if err := serveGPSD(ctx, gpsdAddr, data, live); err != nil {
+				log.Printf("error serving gpsd protocol: %v\n", err)
+			}
+		}()
+	}
+	if cfg.NMEAAddr != "" {
+		go func() {
+			if err := serveLines(cfg.NMEAAddr, "NMEA", "\r\n", nmeaOut); err != nil {
+				log.Printf("error serving NMEA: %v\n", err)
+			}
+		}()
+	}
+	if cfg.SignalKAddr != "" {
+		go func() {
+			if err := serveSignalK(cfg.SignalKAddr, signalK); err != nil {
+				log.Printf("error serving Signal K: %v\n", err)
+			}
+		}()
+	}
+
+	// locationFix reads the current location to log
+	locationFix := func() (Fix, error) {
+		c := live.Get()
 		data.Lock()
 		defer data.Unlock()
-		// try to add a new piece of data
-		if data.RMC == nil || data.GGA == nil {
-			return ErrNoDataToLog
+		if data.RMC == nil {
+			return Fix{}, ErrNoDataToLog
+		}
+		// the stream may have dropped silently, leaving the last fix behind
+		if !data.FixFresh(c.StaleAfter) {
+			return Fix{}, fmt.Errorf("%w: last fix was %s ago", ErrNoDataToLog, time.Since(data.LastFix).Round(time.Second))
+		}
+		// GGA can stop while RMC keeps coming, which is the same as not having it
+		gga := data.GGA
+		if !data.GGAFresh(c.StaleAfter) {
+			gga = nil
+		}
+		// without GGA, optionally log a 2D point
+		if gga == nil && !c.Allow2D {
+			return Fix{}, ErrNoDataToLog
 		}
-		log.Print("queuing location")
-		t, err := time.Parse("02/01/06T15:04:05.9999", fmt.Sprintf("%sT%s", data.RMC.Date.String(), data.RMC.Time.String()))
+		// the receiver has lost its fix since the last valid RMC
+		if data.RMCValidity != nmea.ValidRMC {
+			rejectedByQuality++
+			return Fix{}, fmt.Errorf("%w: RMC is invalid", ErrFixQuality)
+		}
+		// already validated
+		qualities, _ := parseFixQualities(c.FixQualities)
+		if err := checkFixQuality(data, qualities); err != nil {
+			rejectedByQuality++
+			return Fix{}, err
+		}
+		// without GSA we can't check PDOP, so either skip logging or log anyway
+		if data.GSA == nil && c.RequireGSA {
+			return Fix{}, ErrNoDataToLog
+		}
+		// fixes with a worse DOP than these aren't logged, 0 disables the checks
+		if hdop, ok := currentHDOP(data); ok && c.MaxHDOP > 0 && hdop > c.MaxHDOP {
+			rejectedByDOP++
+			return Fix{}, fmt.Errorf("%w: hdop %.1f > %.1f", ErrDOPTooHigh, hdop, c.MaxHDOP)
+		}
+		if data.GSA != nil && c.MaxPDOP > 0 && data.GSA.PDOP > c.MaxPDOP {
+			rejectedByDOP++
+			return Fix{}, fmt.Errorf("%w: pdop %.1f > %.1f", ErrDOPTooHigh, data.GSA.PDOP, c.MaxPDOP)
+		}
+		t, err := fixTime(data.RMC, data.ZDA)
 		if err != nil {
-			return fmt.Errorf("failed to parse RMC date time: %w", err)
-		}
-		queue = append(queue, queuedOp{
-			query: `INSERT INTO gps_logs(logged_at, gps_timestamp, gps_geometry, gps_speed, gps_course) VALUES($1, $2, ST_GeographyFromText($3), $4, $5)`,
-			args: []interface{}{
-				time.Now(),
-				t,
-				fmt.Sprintf("SRID=4326;POINTZ(%f %f %f)", data.RMC.Longitude, data.RMC.Latitude, data.GGA.Altitude),
-				data.RMC.Speed,
-				data.RMC.Course,
-			},
-		})
-		// don't infinitely take up memory
-		queue = queue[:max(len(queue)-100, len(queue))]
+			return Fix{}, err
+		}
+		raw := position{Latitude: data.RMC.Latitude, Longitude: data.RMC.Longitude}
+		if gga != nil {
+			raw.Altitude, err = altitudeMeters(gga.Altitude, ggaAltitudeUnits(gga))
+			if err != nil {
+				return Fix{}, fmt.Errorf("failed to read GGA altitude: %w", err)
+			}
+		}
+		loggedAt := time.Now()
+		if c.Source == sourceReplay {
+			// backfilling, so log it as of when it happened
+			loggedAt = t
+		}
+		fix := Fix{
+			LoggedAt:    loggedAt,
+			Time:        t,
+			Position:    raw,
+			HasAltitude: gga != nil,
+			Speed:       data.RMC.Speed,
+			Course:      data.RMC.Course,
+		}
+		if data.Smoothed != nil {
+			fix.Position = *data.Smoothed
+			// smoothed before GGA came in, so there's only the raw altitude
+			if !data.smoothedAltitude {
+				fix.Position.Altitude = raw.Altitude
+			}
+			fix.Raw = &raw
+		}
+		// take speed and course from VTG rather than RMC when we have it
+		if c.SpeedSource == speedSourceVTG && data.VTG != nil {
+			if speed, course, ok := vtgSpeedCourse(data.VTG); ok {
+				fix.Speed, fix.Course = speed, course
+			}
+		}
+		if data.GSA != nil {
+			if accuracy, ok := horizontalAccuracy(data.GSA.HDOP, cfg.UERE); ok {
+				fix.Accuracy = &accuracy
+			}
+		}
+		if data.GST != nil {
+			fix.Deviation = gstDeviation(data.GST)
+		}
+		fix.Heading = data.TrueHeading()
+		fix.TripID = trips.Current()
+		return fix, nil
+	}
+
+	requestFlush := func() {
+		select {
+		case flushNow <- struct{}{}:
+		default:
+		}
+	}
+
+	// queueFix queues a fix to be stored, flushing early if needed
+	queueFix := func(ctx context.Context, fix Fix) error {
+		// when blocking, a push has to make space
+		if queued, _ := storage.Queued(); queued+1 >= flushAt {
+			requestFlush()
+		}
+		n, err := storage.Enqueue(ctx, fix)
+		if n >= flushAt {
+			// don't wait for the next scheduled flush
+			requestFlush()
+		}
+		return err
+	}
+
+	// filterFix returns an error if a fix from the receiver shouldn't be
+	// logged, otherwise it's taken to be logged
+	filterFix := func(fix Fix) error {
+		c := live.Get()
+		if err := outliers.Check(fix, c.MaxSpeed); err != nil {
+			return err
+		}
+		return stationary.Check(fix, c.StationaryRadius, c.StationaryHeartbeat)
+	}
+
+	// traveled returns the meters traveled since the last logged location
+	traveled := func() float64 {
+		data.Lock()
+		defer data.Unlock()
+		return data.sinceLogged
+	}
+
+	// queueLogged queues a fix from the receiver
+	queueLogged := func(ctx context.Context, fix Fix) error {
+		if err := filterFix(fix); err != nil {
+			return err
+		}
+		if err := queueFix(ctx, fix); err != nil {
+			return err
+		}
+		data.Lock()
+		data.sinceLogged = 0
+		data.Unlock()
 		return nil
 	}
 
-	pushToDB := func(db *sql.DB) error {
+	queueLocation := func(ctx context.Context) error {
+		// try to add a new piece of data
+		fix, err := locationFix()
+		if err != nil {
+			return err
+		}
+		return queueLogged(ctx, fix)
+	}
+
+	http.HandleFunc("/api/ingest", tokenFromQuery(requireToken(apiToken, ingestHandler(queueFix, cfg.UERE))))
+
+	// pushToDB writes everything queued to the DB, returning how many rows were written
+	pushToDB := func(ctx context.Context) (int, error) {
 		defer func() {
+			pushStatusMu.Lock()
 			lastAttemptedPush = time.Now()
+			pushStatusMu.Unlock()
 		}()
-		log.Printf("pushing GPS data (%d in queue)\n", len(queue))
-		tx, err := db.Begin()
+		n, err := storage.Flush(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to start db txn: %w", err)
-		}
-		for len(queue) != 0 {
-			// pop the first item in the queue, work through list
-			op := queue[0]
-			if _, err := tx.Exec(op.query, op.args...); err != nil {
-				return fmt.Errorf("failed to insert to DB: %w", err)
-			}
-			queue = queue[1:]
-		}
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit db txn: %w", err)
+			return 0, err
 		}
+		pushStatusMu.Lock()
 		lastSuccessfulPush = time.Now()
-		return nil
+		pushStatusMu.Unlock()
+		return n, nil
 	}
 
-	wg.Add(1)
-	go func() {
-		db, err := sql.Open("postgres", connStr)
+	if storeDB != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := storeDB.PingContext(pingCtx); err != nil {
+				// not fatal, the queue will hold data until the db is reachable
+				log.Printf("error pinging DB: %v\n", err)
+			} else if db != nil {
+				if err := checkTimestampColumns(pingCtx, db); err != nil {
+					log.Printf("warning: %v\n", err)
+				}
+			}
+			cancel()
+			failing := false
+			for {
+				_, err := pushToDB(ctx)
+				if err != nil {
+					log.Printf("error pushing GPS data: %v\n", err)
+				}
+				// only starting to fail and recovering are events, not every
+				// failed push
+				if (err != nil) != failing {
+					failing = err != nil
+					e := Event{Type: eventDBRestored, Time: time.Now(), Message: "pushing to the DB works again"}
+					if failing {
+						e = Event{Type: eventDBError, Time: time.Now(), Message: fmt.Sprintf("failed to push to the DB: %v", err)}
+					}
+					events.Emit(e)
+				}
+				select {
+				case <-time.After(live.Get().PushInterval):
+				case <-flushNow:
+				case <-ctx.Done():
+					// the final push happens once everything else has stopped
+					return
+				}
+			}
+		}()
+	}
+
+	http.HandleFunc("/api/flush", requireToken(apiToken, func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var res struct {
+			Queued     bool   `json:"queued"`
+			QueueError string `json:"queue_error,omitempty"`
+			Written    int    `json:"written"`
+		}
+		if err := queueLocation(r.Context()); err != nil {
+			res.QueueError = err.Error()
+		} else {
+			res.Queued = true
+		}
+		written, err := pushToDB(r.Context())
 		if err != nil {
-			panic(err)
+			internalError(rw, fmt.Errorf("failed to flush: %w", err))
+			return
 		}
-		log.Println("opened DB connection")
-		defer db.Close()
-		for {
-			if err := pushToDB(db); err != nil {
-				log.Printf("error pushing GPS data: %v\n", err)
-			}
-			time.Sleep(time.Minute * 5)
+		res.Written = written
+		log.Printf("manually flushed %d rows\n", written)
+		writeJSON(rw, res)
+	}))
+
+	highResActive := func() bool {
+		data.Lock()
+		defer data.Unlock()
+		return time.Now().Before(highResUntil)
+	}
+
+	// locationInterval returns how long to wait between logging locations at
+	// the current speed
+	locationInterval := func() time.Duration {
+		c := live.Get()
+		// already validated
+		intervals, _ := parseAdaptiveIntervals(c.AdaptiveIntervals)
+		data.Lock()
+		defer data.Unlock()
+		var speed float64
+		if data.FixFresh(c.StaleAfter) {
+			speed = data.RMC.Speed
 		}
-	}()
+		return intervals.For(speed, c.LogInterval)
+	}
 
 	wg.Add(1)
 	go func() {
-		time.Sleep(time.Second * 10)
+		defer wg.Done()
+		select {
+		case <-time.After(time.Second * 10):
+		case <-ctx.Done():
+			return
+		}
+		next := time.Now()
+		// when we last tried logging on schedule
+		var last time.Time
+		// until the first location is logged, keep trying every warmupInterval
+		// so we don't wait a whole interval for a cold start to get a fix
+		warmingUp := warmupInterval > 0
 		for {
-			if err := queueLocation(); err != nil {
-				if errors.Is(err, ErrNoDataToLog) {
-					log.Println("skipped queuing, no data")
-				} else {
+			select {
+			case <-time.After(time.Until(next)):
+				last = time.Now()
+				next = last.Add(locationInterval())
+				// replayed fixes are logged by their own time instead
+				if live.Get().Source == sourceReplay {
+					continue
+				}
+				if !warmingUp {
+					log.Print("queuing location")
+				}
+				err := queueLocation(ctx)
+				if warmingUp {
+					if skippedFix(err) {
+						next = time.Now().Add(warmupInterval)
+						continue
+					}
+					warmingUp = false
+					if err == nil {
+						log.Println("queued first location")
+					}
+				}
+				if err != nil {
+					if errors.Is(err, ErrNoDataToLog) {
+						log.Println("skipped queuing, no data")
+					} else if skippedFix(err) {
+						log.Printf("skipped queuing, %v\n", err)
+					} else {
+						log.Printf("error queuing location: %v\n", err)
+					}
+				}
+			case <-newFix:
+				// speeding up shortens the wait, rather than waiting out the
+				// long interval from when we were stopped
+				if !warmingUp && !last.IsZero() && live.Get().AdaptiveIntervals != "" {
+					if n := last.Add(locationInterval()); n.Before(next) {
+						next = n
+					}
+				}
+				c := live.Get()
+				// replayed fixes are logged as they're read instead
+				if c.Source == sourceReplay {
+					continue
+				}
+				// in high resolution mode every fix is logged, otherwise
+				// one every log_distance meters when set
+				if !highResActive() && (c.LogDistance <= 0 || traveled() < c.LogDistance) {
+					continue
+				}
+				if err := queueLocation(ctx); err != nil && !skippedFix(err) {
 					log.Printf("error queuing location: %v\n", err)
 				}
+			case <-ctx.Done():
+				return
 			}
-			time.Sleep(time.Minute * 15)
 		}
 	}()
 
-	parseGPS := func(line []byte) error {
-		s, err := nmea.Parse(string(line))
-		if err != nil {
-			return fmt.Errorf("failed to parse nmea line: %w", err)
+	reader.onFix = func(prev *nmea.RMC, m nmea.RMC) {
+		if prev != nil {
+			step := trackStep(prev, &m)
+			data.sinceLogged += step
+			odo.Add(step)
 		}
-		data.Lock()
-		defer data.Unlock()
-		switch s.DataType() {
-		case nmea.TypeRMC:
-			// Recommended Minimum Specific GPS/Transit data
-			m := s.(nmea.RMC)
-			if m.Validity == nmea.ValidRMC {
-				data.RMC = &m
+		if t, err := fixTime(&m, data.ZDA); err == nil {
+			trips.Update(&m, t, live.Get().TripStop)
+			if fences != nil {
+				for _, e := range fences.Update(m.Latitude, m.Longitude, t) {
+					events.Emit(e)
+				}
 			}
-			// log.Println("parsed RMC	")
-		case nmea.TypeGGA:
-			// GPS Positioning System Fix Data
-			m := s.(nmea.GGA)
-			if m.FixQuality != nmea.Invalid {
-				data.GGA = &m
+			c := live.Get()
+			if e := speeding.Update(&m, t, c.SpeedLimit, c.SpeedLimitFor); e != nil {
+				events.Emit(*e)
 			}
-			// log.Println("parsed GGA")
-		case nmea.TypeGSA:
-			// GPS DOP and active satellites
-			m := s.(nmea.GSA)
-			data.GSA = &m
-			// log.Println("parsed GSA")
-		case nmea.TypeGSV:
-			// GPS Satellites in view
-			m := s.(nmea.GSV)
-			data.GSV = &m
-			// log.Println("parsed GSV")
-		case nmea.TypeVTG:
-			// Track Made Good and Ground Speed
-			m := s.(nmea.VTG)
-			data.VTG = &m
-			// log.Println("parsed VTG")
+		}
+		select {
+		case newFix <- struct{}{}:
 		default:
-			return fmt.Errorf("unexpected nmea data type: %s", s.DataType())
 		}
-		return nil
-	}
-
-	getGPS := func() error {
-		http0_9Transport := &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				realConn, err := net.Dial(network, addr)
-				if err != nil {
-					return nil, err
-				}
-				return &Http0_9ConnWrapper{Conn: realConn}, nil
-			},
+		fix := *currentFixFrom(data, cfg.UERE)
+		recent.Push(fix)
+		if mqttPub != nil {
+			mqttPub.Publish(fix)
 		}
-
-		ctx := context.Background()
-		server := "http://192.168.1.1:11010"
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server, nil)
-		if err != nil {
-			return err
+		if owntracks != nil {
+			owntracks.Publish(fix)
 		}
-		client := &http.Client{
-			Transport: http0_9Transport,
+		if traccar != nil {
+			traccar.Publish(fix)
 		}
-		res, err := client.Do(req)
-		if err != nil {
-			return err
+		if aprs != nil {
+			aprs.Publish(fix)
+		}
+		if cot != nil {
+			cot.Publish(fix)
 		}
-		log.Println("connected to GPS HTTP stream")
+		signalK.Publish(fix)
+	}
+	reader.onSentence = func(sentence []byte) {
+		nmeaOut.Send(sentence)
+		if nmeaUDP != nil {
+			nmeaUDP.Send(sentence)
+		}
+	}
 
-		reader := bufio.NewReader(res.Body)
-		for {
-			line, _, err := reader.ReadLine()
-			if errors.Is(err, io.EOF) {
-				return errors.New("reached end of connection to mifi")
+	reader.replayLogger = func(c Config) func(ctx context.Context) error {
+		// the time of the last fix queued
+		var lastReplayed time.Time
+		// replayed fixes are logged every interval of their time rather than
+		// ours, as they're read so none are missed when replaying quickly
+		return func(ctx context.Context) error {
+			fix, err := locationFix()
+			if err != nil {
+				return nil
+			}
+			// several sentences make up each fix
+			if fix.Time.Equal(lastReplayed) {
+				return nil
 			}
+			gap := fix.Time.Sub(lastReplayed)
+			// already validated
+			intervals, _ := parseAdaptiveIntervals(c.AdaptiveIntervals)
+			intervalDue := lastReplayed.IsZero() || gap <= 0 || gap >= intervals.For(fix.Speed, c.LogInterval)
+			distanceDue := c.LogDistance > 0 && traveled() >= c.LogDistance
+			if !highResActive() && !intervalDue && !distanceDue {
+				return nil
+			}
+			lastReplayed = fix.Time
+			return queueLogged(ctx, fix)
+		}
+	}
+	reader.onReplayDone = requestFlush
+
+	// signalled when the stream needs to reconnect to apply reloaded settings
+	reconnect := make(chan struct{}, 1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			changed, err := live.Reload()
 			if err != nil {
-				return err
+				log.Printf("keeping current config: %v\n", err)
+				continue
 			}
-			line = bytes.Trim(line, "\x00")
-			if string(line) == "" {
+			if len(changed) == 0 {
+				log.Printf("reloaded config, nothing changed\n")
 				continue
 			}
-			if err := parseGPS(line); err != nil {
-				return fmt.Errorf("failed to parse gps line: %w", err)
+			log.Printf("reloaded config, changed %s\n", strings.Join(changed, ", "))
+			for _, name := range changed {
+				if sourceSettings[name] {
+					select {
+					case reconnect <- struct{}{}:
+					default:
+					}
+					break
+				}
 			}
 		}
-	}
+	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			if err := getGPS(); err != nil {
-				log.Println("error getting GPS", err)
-				data.Clear()
-			}
-			time.Sleep(time.Minute)
-		}
+		reader.Run(ctx, reconnect)
 	}()
 
 	wg.Wait()
+
+	odo.Save()
+
+	if capture != nil {
+		if err := capture.Close(); err != nil {
+			log.Printf("error closing capture file: %v\n", err)
+		}
+	}
+
+	if storeDB != nil {
+		// a fresh context, ours is already cancelled
+		n, err := pushToDB(context.Background())
+		if err != nil {
+			log.Printf("error pushing GPS data before exiting: %v\n", err)
+		} else {
+			log.Printf("pushed %d rows before exiting\n", n)
+		}
+		if queued, _ := storage.Queued(); queued > 0 {
+			log.Printf("exiting with %d positions that couldn't be pushed\n", queued)
+		}
+		if err := storeDB.Close(); err != nil {
+			log.Printf("error closing DB: %v\n", err)
+		}
+	}
+	log.Println("shut down")
 }
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []string
+	}{
+		{
+			"one sentence",
+			"$GPGGA,1*00",
+			[]string{"$GPGGA,1*00"},
+		},
+		{
+			"two sentences on one line",
+			"$GPGGA,1*00$GPRMC,2*00",
+			[]string{"$GPGGA,1*00", "$GPRMC,2*00"},
+		},
+		{
+			"leading noise",
+			"\x00garbage$GPGGA,1*00",
+			[]string{"$GPGGA,1*00"},
+		},
+		{
+			"crlf inside the line",
+			"$GPGGA,1*00\r\n$GPRMC,2*00\r\n",
+			[]string{"$GPGGA,1*00", "$GPRMC,2*00"},
+		},
+		{
+			"lone $",
+			"$GPGGA,1*00$",
+			[]string{"$GPGGA,1*00"},
+		},
+		{
+			"no $",
+			"GPGGA,1*00",
+			nil,
+		},
+		{
+			"empty",
+			"",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sentences []string
+			for _, s := range splitSentences([]byte(test.line)) {
+				sentences = append(sentences, string(s))
+			}
+			if !reflect.DeepEqual(sentences, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, sentences)
+			}
+		})
+	}
+}
+
+func TestAltitudeMeters(t *testing.T) {
+	tests := []struct {
+		units    string
+		altitude float64
+		expected float64
+	}{
+		{"M", 100, 100},
+		{"F", 1000, 304.8},
+		{"", 100, 100},
+		{"M", -20, -20},
+	}
+	for _, test := range tests {
+		t.Run(test.units, func(t *testing.T) {
+			meters, err := altitudeMeters(test.altitude, test.units)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(meters-test.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v", test.expected, meters)
+			}
+		})
+	}
+
+	if _, err := altitudeMeters(100, "K"); err == nil {
+		t.Error("expected an error for unknown units")
+	}
+}
+
+var (
+	testRMC = nmeaSentence("GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W")
+	testGGA = nmeaSentence("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+)
+
+// fakeRead is what a fakeSource does for one call to Read
+type fakeRead struct {
+	lines []string
+	// returned once the lines are sent, unless hang is set
+	err error
+	// wait until cancelled after sending the lines
+	hang bool
+}
+
+// fakeSource sends scripted lines, one fakeRead for each call to Read. Once
+// the script runs out, reads wait until cancelled.
+type fakeSource struct {
+	m       sync.Mutex
+	reads   []fakeRead
+	started []time.Time
+	// the error each read returned
+	returned []error
+}
+
+func (s *fakeSource) Read(ctx context.Context, lines chan<- []byte) error {
+	s.m.Lock()
+	i := len(s.started)
+	s.started = append(s.started, time.Now())
+	s.m.Unlock()
+	err := s.read(ctx, i, lines)
+	s.m.Lock()
+	s.returned = append(s.returned, err)
+	s.m.Unlock()
+	return err
+}
+
+func (s *fakeSource) read(ctx context.Context, i int, lines chan<- []byte) error {
+	if i >= len(s.reads) {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r := s.reads[i]
+	for _, line := range r.lines {
+		if !sendLine(ctx, lines, []byte(line)) {
+			return ctx.Err()
+		}
+	}
+	if r.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return r.err
+}
+
+func (s *fakeSource) String() string {
+	return "fake source"
+}
+
+// Reads returns when each read started, and the errors from those that
+// returned
+func (s *fakeSource) Reads() ([]time.Time, []error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]time.Time(nil), s.started...), append([]error(nil), s.returned...)
+}
+
+// testReader is a reader for source, recording failures, which it backs off
+// from for delay
+type testReader struct {
+	*nmeaReader
+	m      sync.Mutex
+	errors []error
+}
+
+func newTestReader(c Config, source Source, delay time.Duration) *testReader {
+	r := &testReader{nmeaReader: newNMEAReader(&MifiNMEAData{}, newLiveConfig(c, nil), nil)}
+	r.newSource = func(Config) (Source, error) {
+		return source, nil
+	}
+	r.failed = func(err error) time.Duration {
+		r.m.Lock()
+		defer r.m.Unlock()
+		r.errors = append(r.errors, err)
+		return delay
+	}
+	return r
+}
+
+func (r *testReader) Failures() []error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+// run runs the reader until the returned function is called, which waits for
+// it to stop
+func (r *testReader) run(t *testing.T) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.Run(ctx, make(chan struct{}))
+	}()
+	return func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("reader didn't stop when cancelled")
+		}
+	}
+}
+
+// waitFor waits for ok to be true
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReaderGoodLines(t *testing.T) {
+	source := &fakeSource{reads: []fakeRead{{
+		lines: []string{
+			testGGA,
+			// several sentences on a line, with noise
+			"\x00" + testRMC + "\r\n" + nmeaSentence("GPVTG,084.4,T,,M,022.4,N,041.5,K"),
+		},
+		hang: true,
+	}}}
+	r := newTestReader(Config{}, source, time.Millisecond)
+	var fixes []float64
+	r.onFix = func(prev *nmea.RMC, m nmea.RMC) {
+		fixes = append(fixes, m.Latitude)
+	}
+	var sentences []string
+	r.onSentence = func(sentence []byte) {
+		sentences = append(sentences, string(sentence))
+	}
+	stop := r.run(t)
+	waitFor(t, "VTG", func() bool {
+		r.data.Lock()
+		defer r.data.Unlock()
+		return r.data.VTG != nil
+	})
+	stop()
+
+	r.data.Lock()
+	defer r.data.Unlock()
+	if r.data.RMC == nil || r.data.RawRMC != testRMC {
+		t.Errorf("expected RMC %q, got %q", testRMC, r.data.RawRMC)
+	}
+	if r.data.GGA == nil || r.data.GGA.Altitude != 545.4 {
+		t.Errorf("expected GGA, got %+v", r.data.GGA)
+	}
+	if len(fixes) != 1 || math.Abs(fixes[0]-48.1173) > 1e-9 {
+		t.Errorf("expected one fix at 48.1173, got %v", fixes)
+	}
+	if len(sentences) != 3 {
+		t.Errorf("expected 3 sentences passed on, got %q", sentences)
+	}
+	if failures := r.Failures(); len(failures) != 0 {
+		t.Errorf("expected no failures, got %v", failures)
+	}
+}
+
+func TestReaderSkipsRecoverableErrors(t *testing.T) {
+	source := &fakeSource{reads: []fakeRead{{
+		lines: []string{
+			testRMC,
+			// bad checksum
+			testGGA[:len(testGGA)-2] + "00",
+			"garbage",
+			// not supported, skipped in lenient mode
+			nmeaSentence("GPXYZ,1,2,3"),
+			// still parsing
+			testGGA,
+		},
+		hang: true,
+	}}}
+	r := newTestReader(Config{Lenient: true}, source, time.Millisecond)
+	stop := r.run(t)
+	waitFor(t, "GGA", func() bool {
+		r.data.Lock()
+		defer r.data.Unlock()
+		return r.data.GGA != nil
+	})
+	stop()
+
+	r.data.Lock()
+	defer r.data.Unlock()
+	if r.data.RMC == nil {
+		t.Error("expected RMC to be kept, data was cleared")
+	}
+	if r.data.corruptSentences != 2 {
+		t.Errorf("expected 2 corrupt sentences, got %d", r.data.corruptSentences)
+	}
+	if r.data.skippedSentences != 1 {
+		t.Errorf("expected 1 skipped sentence, got %d", r.data.skippedSentences)
+	}
+	if started, _ := source.Reads(); len(started) != 1 {
+		t.Errorf("expected to keep reading from the source, it was read %d times", len(started))
+	}
+	if failures := r.Failures(); len(failures) != 0 {
+		t.Errorf("expected no failures, got %v", failures)
+	}
+}
+
+func TestReaderUnsupportedSentenceFailsWhenStrict(t *testing.T) {
+	source := &fakeSource{reads: []fakeRead{{
+		lines: []string{nmeaSentence("GPXYZ,1,2,3")},
+		hang:  true,
+	}}}
+	r := newTestReader(Config{}, source, time.Hour)
+	stop := r.run(t)
+	waitFor(t, "failure", func() bool {
+		return len(r.Failures()) > 0
+	})
+	stop()
+	if err := r.Failures()[0]; !errors.Is(err, ErrUnsupportedSentence) {
+		t.Errorf("expected an unsupported sentence error, got %v", err)
+	}
+}
+
+func TestReaderClearsAndBacksOffOnError(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	source := &fakeSource{reads: []fakeRead{
+		{lines: []string{testRMC, testGGA}, err: io.ErrUnexpectedEOF},
+		{hang: true},
+	}}
+	r := newTestReader(Config{}, source, delay)
+	stop := r.run(t)
+	waitFor(t, "reconnecting", func() bool {
+		started, _ := source.Reads()
+		return len(started) == 2
+	})
+	stop()
+
+	failures := r.Failures()
+	if len(failures) != 1 || !errors.Is(failures[0], io.ErrUnexpectedEOF) {
+		t.Fatalf("expected one failure from the I/O error, got %v", failures)
+	}
+	started, _ := source.Reads()
+	if gap := started[1].Sub(started[0]); gap < delay {
+		t.Errorf("expected to wait %s before reconnecting, waited %s", delay, gap)
+	}
+	r.data.Lock()
+	defer r.data.Unlock()
+	if r.data.RMC != nil || r.data.GGA != nil {
+		t.Error("expected data to be cleared after the error")
+	}
+	if r.data.LastFix.IsZero() {
+		t.Error("expected when we last got a fix to be kept")
+	}
+}
+
+func TestReaderStopsWhenCancelled(t *testing.T) {
+	source := &fakeSource{reads: []fakeRead{{lines: []string{testRMC}, hang: true}}}
+	r := newTestReader(Config{}, source, time.Millisecond)
+	stop := r.run(t)
+	waitFor(t, "RMC", func() bool {
+		r.data.Lock()
+		defer r.data.Unlock()
+		return r.data.RMC != nil
+	})
+	stop()
+
+	started, returned := source.Reads()
+	if len(started) != 1 {
+		t.Errorf("expected one read, got %d", len(started))
+	}
+	if len(returned) != 1 || !errors.Is(returned[0], context.Canceled) {
+		t.Errorf("expected the read to be cancelled, got %v", returned)
+	}
+	if failures := r.Failures(); len(failures) != 0 {
+		t.Errorf("expected cancelling not to count as a failure, got %v", failures)
+	}
+}
+
+func TestReaderReadTimeout(t *testing.T) {
+	const readTimeout = 50 * time.Millisecond
+	// a mifi that sends a sentence then goes quiet, keeping the connection open
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var m sync.Mutex
+	var accepted []time.Time
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m.Lock()
+			accepted = append(accepted, time.Now())
+			m.Unlock()
+			defer conn.Close()
+			fmt.Fprintf(conn, "%s\r\n", testRMC)
+		}
+	}()
+	r := newTestReader(Config{}, &mifiSource{url: "http://" + ln.Addr().String(), readTimeout: readTimeout}, time.Millisecond)
+	stop := r.run(t)
+	waitFor(t, "reconnecting", func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return len(accepted) >= 2
+	})
+	stop()
+
+	failures := r.Failures()
+	if len(failures) == 0 || !strings.Contains(failures[0].Error(), "no data from mifi in 50ms") {
+		t.Fatalf("expected the read timeout to fire, got %v", failures)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if gap := accepted[1].Sub(accepted[0]); gap < readTimeout {
+		t.Errorf("expected to wait %s for data, reconnected after %s", readTimeout, gap)
+	}
+}
+
+func TestReaderWatchdog(t *testing.T) {
+	const watchdog = 50 * time.Millisecond
+	source := &fakeSource{reads: []fakeRead{
+		// the connection stays open, sending only noise
+		{lines: []string{testRMC, "\x00\x00"}, hang: true},
+		{hang: true},
+	}}
+	r := newTestReader(Config{StreamWatchdog: watchdog}, source, time.Millisecond)
+	stop := r.run(t)
+	waitFor(t, "reconnecting", func() bool {
+		started, _ := source.Reads()
+		return len(started) == 2
+	})
+	stop()
+
+	failures := r.Failures()
+	if len(failures) != 1 || !strings.Contains(failures[0].Error(), "no sentences from fake source in 50ms") {
+		t.Fatalf("expected the watchdog to fire, got %v", failures)
+	}
+	started, returned := source.Reads()
+	if gap := started[1].Sub(started[0]); gap < watchdog {
+		t.Errorf("expected the watchdog to wait %s, reconnected after %s", watchdog, gap)
+	}
+	if len(returned) == 0 || !errors.Is(returned[0], context.Canceled) {
+		t.Errorf("expected the first read to be cancelled, got %v", returned)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the standard address of the mifi's NMEA stream when directly connected to it
+const defaultMifiAddr = "192.168.1.1:11010"
+
+const defaultMifiPort = "11010"
+
+// mifiURL turns the address of the mifi's NMEA stream into a URL. The address
+// can be a host:port, a bare host (using the default port), or a full http URL.
+// Hosts can be IPv4 or IPv6 literals, with or without brackets, or DNS names.
+func mifiURL(addr string) (string, error) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid mifi URL: %w", err)
+		}
+		if u.Scheme != "http" {
+			return "", fmt.Errorf("unsupported mifi URL scheme %q", u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("missing host in mifi URL %q", addr)
+		}
+		return u.String(), nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port, which could also be a bare IPv6 literal like ::1
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		port = defaultMifiPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("missing host in mifi address %q", addr)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid IPv6 address in mifi address %q", addr)
+	}
+	return (&url.URL{Scheme: "http", Host: net.JoinHostPort(host, port)}).String(), nil
+}
+
+// the response header faked for the mifi's headerless HTTP 0.9 responses,
+// to make the default go http client happier
+const http0_9Header = "HTTP/1.1 200 OK\r\nConnection: keep-alive\r\nContent-Type: text/plain\r\n\r\n"
+
+type Http0_9ConnWrapper struct {
+	net.Conn
+	haveReadAny bool
+	// closed once the request is sent, the transport treats a response
+	// before then as unsolicited and drops the connection
+	requested   chan struct{}
+	requestOnce sync.Once
+	// what's left of the fake header to read
+	header []byte
+
+	// if set, each read fails if no data arrives within this window
+	readTimeout time.Duration
+}
+
+func (c *Http0_9ConnWrapper) Read(b []byte) (int, error) {
+	if !c.haveReadAny {
+		<-c.requested
+		c.haveReadAny = true
+		c.header = []byte(http0_9Header)
+	}
+	// the header may not fit in one read
+	if len(c.header) > 0 {
+		n := copy(b, c.header)
+		c.header = c.header[n:]
+		return n, nil
+	}
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *Http0_9ConnWrapper) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.requestOnce.Do(func() { close(c.requested) })
+	return n, err
+}
+
+func (c *Http0_9ConnWrapper) Close() error {
+	c.requestOnce.Do(func() { close(c.requested) })
+	return c.Conn.Close()
+}
+
+// mifiSource reads the mifi's NMEA stream, which it serves as HTTP 0.9
+type mifiSource struct {
+	url string
+	// how long the stream can go quiet before we consider it dead
+	readTimeout time.Duration
+}
+
+func (s *mifiSource) String() string {
+	return "mifi"
+}
+
+func (s *mifiSource) Read(ctx context.Context, lines chan<- []byte) error {
+	dialer := &net.Dialer{Timeout: s.readTimeout}
+	http0_9Transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			realConn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &Http0_9ConnWrapper{Conn: realConn, requested: make(chan struct{}), readTimeout: s.readTimeout}, nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: http0_9Transport,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	scanner := newNMEAScanner(res.Body)
+	for scanner.Scan() {
+		// the scanner reuses its buffer
+		if !sendLine(ctx, lines, append([]byte(nil), scanner.Bytes()...)) {
+			return ctx.Err()
+		}
+	}
+	err = scanner.Err()
+	if err == nil {
+		return errors.New("reached end of connection to mifi")
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no data from mifi in %s", s.readTimeout)
+	}
+	return err
+}
+
+// nmeaHandler re-emits the most recent raw sentence of each type we track, so
+// other NMEA tools can consume them
+func nmeaHandler(data *MifiNMEAData) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		data.Lock()
+		raw := []string{data.RawRMC, data.RawGGA, data.RawGSA, data.RawGSV, data.RawVTG, data.RawGLL, data.RawZDA, data.RawGNS, data.RawGST, data.RawHDT, data.RawTHS}
+		data.Unlock()
+		rw.Header().Set("Content-Type", "text/plain")
+		for _, sentence := range raw {
+			if sentence != "" {
+				// NMEA 0183 lines end with CRLF
+				fmt.Fprintf(rw, "%s\r\n", sentence)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestMifiURL(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"192.168.1.1:11010", "http://192.168.1.1:11010"},
+		{"192.168.1.1", "http://192.168.1.1:11010"},
+		{"[::1]:11010", "http://[::1]:11010"},
+		{"[::1]", "http://[::1]:11010"},
+		{"::1", "http://[::1]:11010"},
+		{"fe80::1", "http://[fe80::1]:11010"},
+		{"mifi.local", "http://mifi.local:11010"},
+		{"mifi.local:8080", "http://mifi.local:8080"},
+		{"http://mifi.local:8080", "http://mifi.local:8080"},
+		{"http://192.168.1.1/nmea", "http://192.168.1.1/nmea"},
+	}
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			u, err := mifiURL(test.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, u)
+			}
+		})
+	}
+}
+
+func TestMifiURLInvalid(t *testing.T) {
+	for _, addr := range []string{
+		"",
+		":11010",
+		"https://mifi.local",
+		"http://",
+		"gg::1",
+	} {
+		t.Run(addr, func(t *testing.T) {
+			if u, err := mifiURL(addr); err == nil {
+				t.Errorf("expected an error, got %s", u)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// how long to wait for the broker to acknowledge a publish
+const mqttPublishTimeout = 10 * time.Second
+
+// haDiscoveryAttributes turns a published fix into Home Assistant device
+// tracker attributes, which need the accuracy as gps_accuracy to place it
+const haDiscoveryAttributes = "{{ dict(value_json, gps_accuracy=value_json.accuracy_m | default(0)) | tojson }}"
+
+// haDiscoveryConfig returns the Home Assistant discovery config for a
+// device_tracker entity following fixes published to topic
+func haDiscoveryConfig(clientID, topic string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"name":                     "Location",
+		"unique_id":                clientID + "_location",
+		"source_type":              "gps",
+		"json_attributes_topic":    topic,
+		"json_attributes_template": haDiscoveryAttributes,
+		"device": map[string]interface{}{
+			"identifiers": []string{clientID},
+			"name":        clientID,
+			"model":       "mifi-gps",
+		},
+	})
+}
+
+// mqttPublisher publishes fixes to an MQTT broker. Fixes are dropped rather
+// than blocking GPS parsing when the broker is down or slow.
+type mqttPublisher struct {
+	client mqtt.Client
+	topic  string
+	// retained so new subscribers get the last position straight away
+	retain bool
+	// only the latest fix is worth publishing
+	fixes chan currentFix
+}
+
+// newMQTTPublisher connects to broker to publish fixes to topic. With a
+// discovery prefix, Home Assistant discovery is published each time it
+// connects, so the tracker shows up by itself.
+func newMQTTPublisher(broker, clientID, username, password, topic string, retain bool, discoveryPrefix string) *mqttPublisher {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Minute).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("connected to MQTT broker %s\n", broker)
+			if discoveryPrefix != "" {
+				publishHADiscovery(c, discoveryPrefix, clientID, topic)
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("lost connection to MQTT broker: %v\n", err)
+		})
+	p := &mqttPublisher{
+		client: mqtt.NewClient(opts),
+		topic:  topic,
+		retain: retain,
+		fixes:  make(chan currentFix, 1),
+	}
+	// retries in the background until the broker is reachable
+	p.client.Connect()
+	go p.run()
+	return p
+}
+
+// Publish queues a fix to be published, replacing any that hasn't been sent
+// yet
+func (p *mqttPublisher) Publish(fix currentFix) {
+	select {
+	case <-p.fixes:
+	default:
+	}
+	select {
+	case p.fixes <- fix:
+	default:
+	}
+}
+
+// publishHADiscovery publishes the Home Assistant discovery config, retained
+// so Home Assistant finds it when it restarts
+func publishHADiscovery(c mqtt.Client, prefix, clientID, topic string) {
+	payload, err := haDiscoveryConfig(clientID, topic)
+	if err != nil {
+		log.Printf("error encoding Home Assistant discovery: %v\n", err)
+		return
+	}
+	token := c.Publish(prefix+"/device_tracker/"+clientID+"/config", 1, true, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		log.Println("timed out publishing Home Assistant discovery")
+	} else if err := token.Error(); err != nil {
+		log.Printf("error publishing Home Assistant discovery: %v\n", err)
+	}
+}
+
+func (p *mqttPublisher) run() {
+	for fix := range p.fixes {
+		payload, err := json.Marshal(fix)
+		if err != nil {
+			log.Printf("error encoding MQTT payload: %v\n", err)
+			continue
+		}
+		if err := p.Send(p.topic, payload, p.retain); err != nil {
+			log.Printf("error publishing to MQTT: %v\n", err)
+		}
+	}
+}
+
+// Send publishes a payload to a topic and waits for the broker to acknowledge
+// it. Payloads are dropped while the broker is unreachable.
+func (p *mqttPublisher) Send(topic string, payload []byte, retain bool) error {
+	if !p.client.IsConnectionOpen() {
+		return nil
+	}
+	token := p.client.Publish(topic, 1, retain, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("timed out")
+	}
+	return token.Error()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nmeaRates limits how often each sentence type, like GSV, is sent. * sets
+// the limit for types that aren't listed.
+type nmeaRates map[string]time.Duration
+
+// parseNMEARates parses comma separated type:interval pairs, like
+// "GSV:5s,GSA:1s,*:500ms". Empty doesn't limit anything.
+func parseNMEARates(s string) (nmeaRates, error) {
+	rates := nmeaRates{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rate %q, expected type:interval", pair)
+		}
+		typ := strings.ToUpper(strings.TrimSpace(pair[:i]))
+		if typ == "" {
+			return nil, fmt.Errorf("invalid sentence type in rate %q", pair)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(pair[i+1:]))
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid interval in rate %q", pair)
+		}
+		rates[typ] = interval
+	}
+	return rates, nil
+}
+
+// For returns the least time between sentences of a type
+func (r nmeaRates) For(typ string) time.Duration {
+	if interval, ok := r[typ]; ok {
+		return interval
+	}
+	return r["*"]
+}
+
+// sentenceType returns a sentence's type without the talker, like GSV for
+// $GPGSV, or the whole address for proprietary sentences
+func sentenceType(sentence []byte) string {
+	address := bytes.TrimPrefix(sentence, []byte("$"))
+	if i := bytes.IndexByte(address, ','); i >= 0 {
+		address = address[:i]
+	}
+	if len(address) == 5 && address[0] != 'P' {
+		return string(address[2:])
+	}
+	return string(address)
+}
+
+// nmeaUDPSender sends sentences to a UDP address, like a LAN's broadcast
+// address, for navigation apps that listen for NMEA over UDP
+type nmeaUDPSender struct {
+	conn  net.Conn
+	rates nmeaRates
+
+	m        sync.Mutex
+	lastSent map[string]time.Time
+	// whether each talker's current GSV set is being sent, by address
+	sendingGSV map[string]bool
+	lastErr    time.Time
+}
+
+func newNMEAUDPSender(addr string, rates nmeaRates) (*nmeaUDPSender, error) {
+	// Go allows sending to broadcast addresses by default
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send NMEA over UDP: %w", err)
+	}
+	return &nmeaUDPSender{conn: conn, rates: rates, lastSent: map[string]time.Time{}, sendingGSV: map[string]bool{}}, nil
+}
+
+// Send sends a sentence, unless one of its type was sent too recently
+func (s *nmeaUDPSender) Send(sentence []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	typ := sentenceType(sentence)
+	now := time.Now()
+	fields := bytes.Split(sentence, []byte(","))
+	if typ == "GSV" && len(fields) > 2 && string(fields[2]) != "1" {
+		// the rest of a GSV set goes with its first sentence
+		if !s.sendingGSV[string(fields[0])] {
+			return
+		}
+	} else {
+		due := now.Sub(s.lastSent[typ]) >= s.rates.For(typ)
+		if typ == "GSV" {
+			s.sendingGSV[string(fields[0])] = due
+		}
+		if !due {
+			return
+		}
+		s.lastSent[typ] = now
+	}
+	if _, err := s.conn.Write([]byte(string(sentence) + "\r\n")); err != nil {
+		// every sentence would fail the same way, only log a sample
+		if now.Sub(s.lastErr) > time.Minute {
+			log.Printf("error sending NMEA over UDP: %v\n", err)
+			s.lastErr = now
+		}
+	}
+}
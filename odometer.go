@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// how often the odometer is saved while moving, it's also saved on shutdown
+const odometerSaveInterval = time.Minute
+
+// odometerReading is the distance traveled, in meters
+type odometerReading struct {
+	Total float64 `json:"total_m"`
+	// since the trip was last reset
+	Trip        float64   `json:"trip_m"`
+	TripStarted time.Time `json:"trip_started"`
+}
+
+func (r odometerReading) TotalKM() float64 {
+	return r.Total / 1000
+}
+
+func (r odometerReading) TripKM() float64 {
+	return r.Trip / 1000
+}
+
+// odometer totals the distance traveled between fixes, optionally saved to a
+// file so it survives restarts
+type odometer struct {
+	m sync.Mutex
+
+	reading odometerReading
+	// empty to only keep it in memory
+	file  string
+	saved time.Time
+}
+
+// newOdometer returns an odometer, loading it from file if there is one
+func newOdometer(file string) (*odometer, error) {
+	o := &odometer{file: file, reading: odometerReading{TripStarted: time.Now()}}
+	if file == "" {
+		return o, nil
+	}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read odometer file: %w", err)
+	}
+	if err := json.Unmarshal(b, &o.reading); err != nil {
+		return nil, fmt.Errorf("failed to read odometer file: %w", err)
+	}
+	return o, nil
+}
+
+// Add adds meters traveled
+func (o *odometer) Add(meters float64) {
+	if meters <= 0 {
+		return
+	}
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.reading.Total += meters
+	o.reading.Trip += meters
+	if time.Since(o.saved) >= odometerSaveInterval {
+		o.save()
+	}
+}
+
+// ResetTrip starts a new trip
+func (o *odometer) ResetTrip() odometerReading {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.reading.Trip = 0
+	o.reading.TripStarted = time.Now()
+	o.save()
+	return o.reading
+}
+
+func (o *odometer) Reading() odometerReading {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.reading
+}
+
+// Save saves the odometer to its file, if it has one
+func (o *odometer) Save() {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.save()
+}
+
+// save writes the reading alongside the file and renames it over the old one
+// so a crash can't lose it, o must be locked
+func (o *odometer) save() {
+	o.saved = time.Now()
+	if o.file == "" {
+		return
+	}
+	b, err := json.Marshal(o.reading)
+	if err != nil {
+		log.Printf("error encoding odometer: %v\n", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.file), filepath.Base(o.file)+".*")
+	if err != nil {
+		log.Printf("error saving odometer: %v\n", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), o.file)
+	}
+	if err != nil {
+		log.Printf("error saving odometer: %v\n", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// while the GPS stream is down, repeats of the same error are only logged this often
+const outageLogInterval = 10 * time.Minute
+
+// reconnecting backs off exponentially from reconnectMinDelay, so a brief
+// WiFi blip is only a short gap in data, up to reconnectMaxDelay, so a long
+// outage doesn't hammer the Mifi
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = time.Minute
+)
+
+// reconnect delays are randomly varied by up to this fraction either way, so
+// devices that lost connectivity together don't all retry in lockstep
+const reconnectJitter = 0.2
+
+// seeded per process so devices get different delays
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// jitter randomly varies d by up to fraction of itself either way. It isn't
+// safe for concurrent use.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	return d + time.Duration((jitterRand.Float64()*2-1)*fraction*float64(d))
+}
+
+// streamStatus tracks whether the GPS stream is up, aggregating repeated
+// errors during an outage into occasional summary log lines
+type streamStatus struct {
+	m sync.Mutex
+
+	up   bool
+	down bool
+	// while down
+	since   time.Time
+	retries int
+	lastErr string
+	lastLog time.Time
+}
+
+// Up records that we're receiving data
+func (s *streamStatus) Up() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.up {
+		return
+	}
+	if s.down {
+		log.Printf("GPS stream restored after %s, %d retries\n", time.Since(s.since).Round(time.Second), s.retries)
+	} else {
+		log.Println("receiving GPS data")
+	}
+	s.up = true
+	s.down = false
+}
+
+// streamState is a snapshot of a streamStatus
+type streamState struct {
+	// false before any data, as well as while down
+	Up   bool `json:"up"`
+	Down bool `json:"down"`
+	// while down
+	DownSince *time.Time `json:"down_since,omitempty"`
+	Retries   int        `json:"retries,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// State returns whether the stream is up, and how long it's been down for
+// when it isn't
+func (s *streamStatus) State() streamState {
+	s.m.Lock()
+	defer s.m.Unlock()
+	state := streamState{Up: s.up, Down: s.down}
+	if s.down {
+		since := s.since
+		state.DownSince = &since
+		state.Retries = s.retries
+		state.LastError = s.lastErr
+	}
+	return state
+}
+
+// backoff is how long to wait before the next retry, doubling with each
+// failed retry
+func backoff(retries int) time.Duration {
+	d := time.Duration(float64(reconnectMinDelay) * math.Pow(2, float64(retries)))
+	if d <= 0 || d > reconnectMaxDelay {
+		d = reconnectMaxDelay
+	}
+	return jitter(d, reconnectJitter)
+}
+
+// Failed records that the stream broke, or that a retry failed, returning
+// how long to wait before retrying
+func (s *streamStatus) Failed(err error) time.Duration {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	if !s.down {
+		s.up = false
+		s.down = true
+		s.since = now
+		s.retries = 0
+		s.lastErr = err.Error()
+		s.lastLog = now
+		delay := backoff(s.retries)
+		log.Printf("GPS stream down, retrying in %s: %v\n", delay.Round(time.Millisecond), err)
+		return delay
+	}
+	s.retries++
+	delay := backoff(s.retries)
+	// once backed off all the way, repeats of the same error are only logged occasionally
+	backedOff := float64(reconnectMinDelay)*math.Pow(2, float64(s.retries)) >= float64(reconnectMaxDelay)
+	if msg := err.Error(); msg != s.lastErr || now.Sub(s.lastLog) >= outageLogInterval || !backedOff {
+		log.Printf("GPS stream down for %s, attempt %d failed, retrying in %s: %v\n", now.Sub(s.since).Round(time.Second), s.retries, delay.Round(time.Millisecond), err)
+		s.lastErr = msg
+		s.lastLog = now
+	}
+	return delay
+}
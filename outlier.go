@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// after this many outliers in a row, the last accepted fix is the one that's
+// wrong, like a glitch during a cold start, so start again from the next fix
+const outlierResetAfter = 3
+
+// ErrOutlier is returned for fixes implying an impossible speed
+var ErrOutlier = fmt.Errorf("fix is an outlier")
+
+// outlierFilter rejects fixes that are too far from the last accepted one to
+// have been reached in the time between them, like multipath glitches
+type outlierFilter struct {
+	m sync.Mutex
+
+	last *Fix
+	// outliers in a row since the last accepted fix
+	streak   int
+	rejected int
+}
+
+// Check returns ErrOutlier if reaching fix from the last accepted fix implies
+// a speed above maxSpeed meters per second, otherwise it's accepted. 0
+// disables the check.
+func (f *outlierFilter) Check(fix Fix, maxSpeed float64) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if maxSpeed > 0 && f.last != nil && f.streak < outlierResetAfter {
+		dt := fix.Time.Sub(f.last.Time).Seconds()
+		// out of order or duplicate times can't be checked
+		if dt > 0 {
+			d := haversine(f.last.Position.Latitude, f.last.Position.Longitude, fix.Position.Latitude, fix.Position.Longitude)
+			if speed := d / dt; speed > maxSpeed {
+				f.streak++
+				f.rejected++
+				return fmt.Errorf("%w: %.0f m in %.0fs is %.0f m/s", ErrOutlier, d, dt, speed)
+			}
+		}
+	}
+	f.last = &fix
+	f.streak = 0
+	return nil
+}
+
+// Rejected returns how many fixes have been rejected
+func (f *outlierFilter) Rejected() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.rejected
+}
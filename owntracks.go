@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// how long to wait for an OwnTracks HTTP endpoint
+const owntracksTimeout = 10 * time.Second
+
+// owntracksLocation is an OwnTracks location message, see
+// https://owntracks.org/booklet/tech/json/
+type owntracksLocation struct {
+	Type string  `json:"_type"`
+	TID  string  `json:"tid"`
+	Time int64   `json:"tst"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+	// meters
+	Accuracy *int `json:"acc,omitempty"`
+	Altitude *int `json:"alt,omitempty"`
+	// km/h
+	Velocity int `json:"vel"`
+	Course   int `json:"cog"`
+	// why it was sent, t for a timer
+	Trigger string `json:"t"`
+}
+
+func owntracksLocationFrom(fix currentFix, tid string) owntracksLocation {
+	loc := owntracksLocation{
+		Type:     "location",
+		TID:      tid,
+		Time:     fix.Time.Unix(),
+		Lat:      fix.Latitude,
+		Lon:      fix.Longitude,
+		Velocity: int(math.Round(fix.Speed * knotsToKPH)),
+		Course:   int(math.Round(fix.Course)),
+		Trigger:  "t",
+	}
+	if fix.Accuracy != nil {
+		accuracy := int(math.Round(*fix.Accuracy))
+		loc.Accuracy = &accuracy
+	}
+	if fix.Altitude != nil {
+		altitude := int(math.Round(*fix.Altitude))
+		loc.Altitude = &altitude
+	}
+	return loc
+}
+
+// owntracksPublisher sends fixes as OwnTracks locations to an MQTT topic, an
+// HTTP endpoint like the OwnTracks Recorder's, or both, so OwnTracks apps and
+// recorders can show it alongside phones.
+type owntracksPublisher struct {
+	throttledSender
+
+	tid string
+
+	// MQTT topic, like owntracks/user/device, empty to not publish to MQTT
+	topic string
+	mqtt  *mqttPublisher
+	// HTTP endpoint, empty to not POST locations
+	url    string
+	client *http.Client
+}
+
+func newOwntracksPublisher(tid string, interval time.Duration, topic string, mqttPub *mqttPublisher, endpoint string) *owntracksPublisher {
+	p := &owntracksPublisher{
+		throttledSender: newThrottledSender(interval),
+		tid:             tid,
+		topic:           topic,
+		mqtt:            mqttPub,
+		url:             endpoint,
+		client:          &http.Client{Timeout: owntracksTimeout},
+	}
+	go p.run()
+	return p
+}
+
+func (p *owntracksPublisher) run() {
+	for fix := range p.fixes {
+		payload, err := json.Marshal(owntracksLocationFrom(fix, p.tid))
+		if err != nil {
+			log.Printf("error encoding OwnTracks location: %v\n", err)
+			continue
+		}
+		if p.topic != "" && p.mqtt != nil {
+			// retained, like OwnTracks apps do
+			if err := p.mqtt.Send(p.topic, payload, true); err != nil {
+				log.Printf("error publishing OwnTracks location to MQTT: %v\n", err)
+			}
+		}
+		if p.url != "" {
+			if err := p.post(payload); err != nil {
+				log.Printf("error sending OwnTracks location: %v\n", err)
+			}
+		}
+	}
+}
+
+func (p *owntracksPublisher) post(payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), owntracksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("OwnTracks endpoint responded %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// proprietaryHandler handles a vendor's proprietary sentence, given its
+// fields after the name. data is locked.
+type proprietaryHandler func(data *MifiNMEAData, fields []string) error
+
+// proprietaryHandlers handle proprietary sentences by name, like PQXFI, and
+// are added to from init in the file handling them. Sentences without a
+// handler are ignored, so vendors' extra sentences don't disrupt logging.
+var proprietaryHandlers = map[string]proprietaryHandler{}
+
+// isProprietary reports whether a line is a proprietary sentence, which
+// start with P and a manufacturer code rather than a talker
+func isProprietary(line string) bool {
+	return strings.HasPrefix(line, "$P")
+}
+
+// splitProprietary splits a proprietary sentence, with its checksum already
+// checked, into its name and fields
+func splitProprietary(line string) (string, []string) {
+	body := line[1:strings.LastIndex(line, nmea.ChecksumSep)]
+	fields := strings.Split(body, nmea.FieldSep)
+	return fields[0], fields[1:]
+}
+
+// handleProprietary passes a proprietary sentence to its handler, or ignores
+// it, logging the first of each we ignore. data must be locked.
+func handleProprietary(data *MifiNMEAData, line string) error {
+	name, fields := splitProprietary(line)
+	if data.LastSeen == nil {
+		data.LastSeen = map[string]time.Time{}
+	}
+	data.LastSeen[name] = time.Now()
+	if handler, ok := proprietaryHandlers[name]; ok {
+		return handler(data, fields)
+	}
+	if data.ignoredProprietary == nil {
+		data.ignoredProprietary = map[string]bool{}
+	}
+	if !data.ignoredProprietary[name] {
+		log.Printf("ignoring proprietary %s sentences\n", name)
+		data.ignoredProprietary[name] = true
+	}
+	return nil
+}
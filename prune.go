@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// pruneLogs deletes logs from before cutoff in batches, so we never hold a
+// long lock on the table, and returns how many rows were deleted
+func pruneLogs(ctx context.Context, db *sql.DB, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		res, err := db.ExecContext(ctx, `DELETE FROM gps_logs WHERE pk IN (SELECT pk FROM gps_logs WHERE logged_at < $1 LIMIT $2)`, cutoff, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete old logs: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to count deleted logs: %w", err)
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// fix qualities, named the same whether they come from GGA's fix quality or
+// RMC's FAA mode
+const (
+	qualityGPS       = "gps"
+	qualityDGPS      = "dgps"
+	qualityPPS       = "pps"
+	qualityRTK       = "rtk"
+	qualityFloatRTK  = "float-rtk"
+	qualityEstimated = "estimated"
+	qualityManual    = "manual"
+	qualitySimulated = "simulated"
+)
+
+// by default only fixes from satellites are logged, not dead reckoning
+const defaultFixQualities = "gps,dgps,pps,rtk,float-rtk"
+
+// ggaQualities names GGA fix qualities
+var ggaQualities = map[string]string{
+	nmea.GPS:  qualityGPS,
+	nmea.DGPS: qualityDGPS,
+	nmea.PPS:  qualityPPS,
+	nmea.RTK:  qualityRTK,
+	nmea.FRTK: qualityFloatRTK,
+	nmea.EST:  qualityEstimated,
+	"7":       qualityManual,
+	"8":       qualitySimulated,
+}
+
+// faaModeQualities names the FAA mode indicators of RMC, in NMEA 2.3 and later
+var faaModeQualities = map[string]string{
+	"A": qualityGPS,
+	"D": qualityDGPS,
+	"P": qualityPPS,
+	"R": qualityRTK,
+	"F": qualityFloatRTK,
+	"E": qualityEstimated,
+	"M": qualityManual,
+	"S": qualitySimulated,
+}
+
+// parseFixQualities parses a comma separated list of fix qualities
+func parseFixQualities(s string) (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, quality := range ggaQualities {
+		known[quality] = true
+	}
+	qualities := map[string]bool{}
+	for _, quality := range strings.Split(s, ",") {
+		quality = strings.TrimSpace(quality)
+		if quality == "" {
+			continue
+		}
+		if !known[quality] {
+			names := make([]string, 0, len(known))
+			for name := range known {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown fix quality %q, expected some of %s", quality, strings.Join(names, ", "))
+		}
+		qualities[quality] = true
+	}
+	if len(qualities) == 0 {
+		return nil, fmt.Errorf("no fix qualities")
+	}
+	return qualities, nil
+}
+
+// checkFixQuality returns an error if the latest GGA fix quality or RMC FAA
+// mode isn't one of the allowed qualities. Either can be missing. data must
+// be locked.
+func checkFixQuality(data *MifiNMEAData, allowed map[string]bool) error {
+	if data.GGAQuality != "" {
+		if data.GGAQuality == nmea.Invalid {
+			return fmt.Errorf("%w: GGA has no fix", ErrFixQuality)
+		}
+		if quality, ok := ggaQualities[data.GGAQuality]; !ok || !allowed[quality] {
+			return fmt.Errorf("%w: GGA fix quality %s", ErrFixQuality, data.GGAQuality)
+		}
+	}
+	if data.RMC != nil && data.RMC.FFAMode != "" {
+		if quality, ok := faaModeQualities[data.RMC.FFAMode]; !ok || !allowed[quality] {
+			return fmt.Errorf("%w: RMC mode %s", ErrFixQuality, data.RMC.FFAMode)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// what to do with new fixes when the queue is full
+const (
+	// drop the oldest queued fix, preferring recent data
+	queueDropOldest = "drop-oldest"
+	// reject the new fix
+	queueDropNewest = "drop-newest"
+	// wait for a flush to make space
+	queueBlock = "block"
+)
+
+var ErrQueueFull = fmt.Errorf("queue is full")
+
+// fixQueue holds fixes until they're stored. It's written while logging and
+// drained while flushing, from different goroutines, so it has its own lock
+// separate from MifiNMEAData's.
+type fixQueue struct {
+	m sync.Mutex
+
+	fixes []Fix
+	// don't infinitely take up memory, policy decides what happens past this
+	max    int
+	policy string
+	// total fixes dropped from the front of a full queue, so a flush knows
+	// how much of what it wrote is still queued
+	dropped int
+	// total new fixes rejected because the queue was full
+	rejected int
+	// signalled when space is made
+	space chan struct{}
+	// if set, fixes are saved here so they survive restarts
+	file string
+}
+
+func newFixQueue(max int, policy string) (*fixQueue, error) {
+	switch policy {
+	case queueDropOldest, queueDropNewest, queueBlock:
+	default:
+		return nil, fmt.Errorf("unknown queue policy %q, expected %s, %s or %s", policy, queueDropOldest, queueDropNewest, queueBlock)
+	}
+	return &fixQueue{max: max, policy: policy, space: make(chan struct{}, 1)}, nil
+}
+
+// Push adds a fix to the end of the queue and returns the new length. What
+// happens when the queue is full depends on its policy, with the block policy
+// waiting until there's space or ctx is done.
+func (q *fixQueue) Push(ctx context.Context, fix Fix) (int, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for len(q.fixes) >= q.max {
+		switch q.policy {
+		case queueDropNewest:
+			q.rejected++
+			return len(q.fixes), ErrQueueFull
+		case queueBlock:
+			q.m.Unlock()
+			select {
+			case <-q.space:
+				q.m.Lock()
+			case <-ctx.Done():
+				q.m.Lock()
+				return len(q.fixes), fmt.Errorf("%w: %v", ErrQueueFull, ctx.Err())
+			}
+			continue
+		}
+		// drop the oldest
+		drop := len(q.fixes) - q.max + 1
+		q.fixes = q.fixes[drop:]
+		q.dropped += drop
+		q.save()
+	}
+	q.fixes = append(q.fixes, fix)
+	q.append(fix)
+	return len(q.fixes), nil
+}
+
+func (q *fixQueue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.fixes)
+}
+
+// Dropped returns how many fixes have been lost because the queue was full,
+// either dropped from the front or rejected
+func (q *fixQueue) Dropped() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.dropped + q.rejected
+}
+
+// queueSnapshot is the queue's contents at a point in time
+type queueSnapshot struct {
+	fixes   []Fix
+	dropped int
+}
+
+// Snapshot returns everything currently queued, without removing it. The
+// queue isn't locked while the snapshot is used, so storing it doesn't block
+// logging.
+func (q *fixQueue) Snapshot() queueSnapshot {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return queueSnapshot{fixes: q.fixes, dropped: q.dropped}
+}
+
+// Remove removes the fixes in s once they've been stored, returning how many
+// were removed, fewer than in s if some were dropped since. Anything queued
+// since the snapshot is kept.
+func (q *fixQueue) Remove(s queueSnapshot) int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	// anything dropped since the snapshot was already at the front
+	n := max(len(s.fixes)-(q.dropped-s.dropped), 0)
+	q.fixes = q.fixes[n:]
+	q.save()
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+	return n
+}
+
+// Persist saves the queue to file as newline delimited JSON from now on, so
+// fixes that haven't been stored survive restarts, and loads anything saved
+// there before, returning how many fixes were loaded. Failing to save is
+// logged rather than failing logging, the queue still works in memory.
+func (q *fixQueue) Persist(file string) (int, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	b, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read queue file: %w", err)
+	}
+	var loaded []Fix
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var fix Fix
+		if err := json.Unmarshal(scanner.Bytes(), &fix); err != nil {
+			// most likely a partial line from being killed mid-write
+			log.Printf("skipping unreadable line in queue file: %v\n", err)
+			continue
+		}
+		loaded = append(loaded, fix)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read queue file: %w", err)
+	}
+	// the queue may have shrunk since they were saved, keep the newest
+	if len(loaded) > q.max {
+		q.dropped += len(loaded) - q.max
+		loaded = loaded[len(loaded)-q.max:]
+	}
+	q.fixes = append(loaded, q.fixes...)
+	q.file = file
+	q.save()
+	return len(loaded), nil
+}
+
+// append saves a newly queued fix, q must be locked
+func (q *fixQueue) append(fix Fix) {
+	if q.file == "" {
+		return
+	}
+	b, err := json.Marshal(fix)
+	if err != nil {
+		log.Printf("error encoding queued fix: %v\n", err)
+		return
+	}
+	f, err := os.OpenFile(q.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("error saving queue: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log.Printf("error saving queue: %v\n", err)
+	}
+}
+
+// save rewrites the whole queue file, q must be locked. The new file is
+// written alongside and renamed over the old one so a crash can't lose it.
+func (q *fixQueue) save() {
+	if q.file == "" {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, fix := range q.fixes {
+		if err := enc.Encode(fix); err != nil {
+			log.Printf("error encoding queued fix: %v\n", err)
+			return
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.file), filepath.Base(q.file)+".*")
+	if err != nil {
+		log.Printf("error saving queue: %v\n", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), q.file)
+	}
+	if err != nil {
+		log.Printf("error saving queue: %v\n", err)
+	}
+}
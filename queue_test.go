@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fixNumbered(i int) Fix {
+	return Fix{Speed: float64(i)}
+}
+
+func TestFixQueueConcurrent(t *testing.T) {
+	const (
+		pushers = 4
+		each    = 500
+	)
+	for _, policy := range []string{queueDropOldest, queueDropNewest, queueBlock} {
+		t.Run(policy, func(t *testing.T) {
+			q, err := newFixQueue(20, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pushing sync.WaitGroup
+			for p := 0; p < pushers; p++ {
+				pushing.Add(1)
+				go func(p int) {
+					defer pushing.Done()
+					for i := 0; i < each; i++ {
+						if _, err := q.Push(context.Background(), fixNumbered(p*each+i)); err != nil && !errors.Is(err, ErrQueueFull) {
+							t.Error(err)
+						}
+					}
+				}(p)
+			}
+			done := make(chan struct{})
+			go func() {
+				pushing.Wait()
+				close(done)
+			}()
+
+			// flush like storage does, reading the snapshot while pushes
+			// carry on
+			removed := 0
+			flush := func() {
+				s := q.Snapshot()
+				for _, fix := range s.fixes {
+					_ = fix.Speed
+				}
+				removed += q.Remove(s)
+			}
+		flushing:
+			for {
+				select {
+				case <-done:
+					break flushing
+				default:
+					flush()
+				}
+			}
+			flush()
+
+			if q.Len() != 0 {
+				t.Errorf("expected an empty queue, got %d", q.Len())
+			}
+			if got := removed + q.Dropped(); got != pushers*each {
+				t.Errorf("expected %d fixes removed or dropped, got %d removed and %d dropped", pushers*each, removed, q.Dropped())
+			}
+		})
+	}
+}
+
+func TestFixQueueRemoveAfterDropOldest(t *testing.T) {
+	q, err := newFixQueue(3, queueDropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		q.Push(ctx, fixNumbered(i))
+	}
+	s := q.Snapshot()
+	// 1 and 2 are evicted while the snapshot is being stored
+	for i := 4; i <= 5; i++ {
+		q.Push(ctx, fixNumbered(i))
+	}
+	if n := q.Remove(s); n != 1 {
+		t.Errorf("expected 1 fix removed, got %d", n)
+	}
+	if q.Dropped() != 2 {
+		t.Errorf("expected 2 fixes dropped, got %d", q.Dropped())
+	}
+	var left []float64
+	for _, fix := range q.Snapshot().fixes {
+		left = append(left, fix.Speed)
+	}
+	if len(left) != 2 || left[0] != 4 || left[1] != 5 {
+		t.Errorf("expected 4 and 5 left, got %v", left)
+	}
+
+	// everything in the snapshot evicted
+	s = q.Snapshot()
+	for i := 6; i <= 8; i++ {
+		q.Push(ctx, fixNumbered(i))
+	}
+	if n := q.Remove(s); n != 0 {
+		t.Errorf("expected no fixes removed, got %d", n)
+	}
+	if q.Len() != 3 {
+		t.Errorf("expected 3 fixes left, got %d", q.Len())
+	}
+}
+
+func TestFixQueueBlockWaitsForRemove(t *testing.T) {
+	q, err := newFixQueue(1, queueBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push(context.Background(), fixNumbered(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Push(ctx, fixNumbered(2)); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull once ctx is done, got %v", err)
+	}
+
+	pushed := make(chan error)
+	go func() {
+		_, err := q.Push(context.Background(), fixNumbered(3))
+		pushed <- err
+	}()
+	if n := q.Remove(q.Snapshot()); n != 1 {
+		t.Errorf("expected 1 fix removed, got %d", n)
+	}
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("push didn't wake up after remove")
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// nmeaReader reads lines from the configured source, parses them into data,
+// and reconnects when the source fails. What else happens with each fix and
+// sentence, like publishing and logging, is left to its hooks.
+type nmeaReader struct {
+	data *MifiNMEAData
+	live *liveConfig
+	// optional, a lossless record of every line read
+	capture *rawCapture
+
+	// creates the source for the current config
+	newSource func(c Config) (Source, error)
+	// records that the stream broke, returning how long to wait before
+	// reconnecting
+	failed func(err error) time.Duration
+	stream *streamStatus
+
+	// called with data locked for each new valid fix, once it's in data.
+	// prev is the fix before, nil if there wasn't one.
+	onFix func(prev *nmea.RMC, m nmea.RMC)
+	// called with each sentence that parsed
+	onSentence func(sentence []byte)
+	// when replaying, called at the start of each read for a function that's
+	// called after each sentence parses
+	replayLogger func(c Config) func(ctx context.Context) error
+	// called when a replay has finished, before waiting for a config reload
+	onReplayDone func()
+}
+
+func newNMEAReader(data *MifiNMEAData, live *liveConfig, capture *rawCapture) *nmeaReader {
+	stream := &streamStatus{}
+	return &nmeaReader{
+		data:      data,
+		live:      live,
+		capture:   capture,
+		newSource: newSource,
+		failed:    stream.Failed,
+		stream:    stream,
+	}
+}
+
+// Parse parses a sentence into data
+func (r *nmeaReader) Parse(line []byte) error {
+	data := r.data
+	if err := checkSentence(line); err != nil {
+		data.Lock()
+		data.corruptSentences++
+		data.Unlock()
+		return err
+	}
+	if isProprietary(string(line)) {
+		data.Lock()
+		defer data.Unlock()
+		return handleProprietary(data, string(line))
+	}
+	s, err := nmea.Parse(string(line))
+	if err != nil {
+		var notSupported *nmea.NotSupportedError
+		if errors.As(err, &notSupported) {
+			return fmt.Errorf("%w: %v", ErrUnsupportedSentence, err)
+		}
+		data.Lock()
+		data.corruptSentences++
+		data.Unlock()
+		return fmt.Errorf("%w: %v", ErrCorruptSentence, err)
+	}
+	data.Lock()
+	defer data.Unlock()
+	if data.LastSeen == nil {
+		data.LastSeen = map[string]time.Time{}
+	}
+	data.LastSeen[s.DataType()] = time.Now()
+	switch s.DataType() {
+	case nmea.TypeRMC:
+		// Recommended Minimum Specific GPS/Transit data
+		m := s.(nmea.RMC)
+		data.RMCValidity = m.Validity
+		if m.Validity == nmea.ValidRMC {
+			data.RawRMC = m.Raw
+			r.setFix(m)
+		}
+	case nmea.TypeGLL:
+		// Geographic Position, sent instead of RMC by some firmware
+		m := s.(nmea.GLL)
+		// only a fallback, RMC has the date, speed and course
+		_, haveRMC := data.LastSeen[nmea.TypeRMC]
+		if !haveRMC {
+			data.RMCValidity = m.Validity
+		}
+		if m.Validity == nmea.ValidGLL {
+			data.GLL = &m
+			data.RawGLL = m.Raw
+			if !haveRMC {
+				r.setFix(gllRMC(&m, data.VTG, time.Now()))
+			}
+		}
+	case nmea.TypeGGA:
+		// GPS Positioning System Fix Data
+		m := s.(nmea.GGA)
+		data.GGAQuality = m.FixQuality
+		if m.FixQuality != nmea.Invalid {
+			data.GGA = &m
+			data.RawGGA = m.Raw
+			data.Updated = time.Now()
+		}
+	case nmea.TypeZDA:
+		// Time & Date
+		m := s.(nmea.ZDA)
+		if m.Time.Valid {
+			data.ZDA = &m
+			data.RawZDA = m.Raw
+		}
+	case nmea.TypeGNS:
+		// GNSS Fix Data, sent instead of GGA by multi-constellation receivers
+		m := s.(nmea.GNS)
+		data.GNS = &m
+		data.RawGNS = m.Raw
+		if _, ok := data.LastSeen[nmea.TypeGGA]; !ok {
+			gga, ok := gnsGGA(&m)
+			data.GGAQuality = gga.FixQuality
+			if ok {
+				data.GGA = &gga
+				data.Updated = time.Now()
+			}
+		}
+	case TypeGST:
+		// GNSS Pseudorange Error Statistics
+		m := s.(GST)
+		data.GST = &m
+		data.RawGST = m.Raw
+	case nmea.TypeHDT:
+		// Heading, True
+		m := s.(nmea.HDT)
+		data.HDT = &m
+		data.RawHDT = m.Raw
+	case nmea.TypeTHS:
+		// True Heading and Status
+		m := s.(nmea.THS)
+		data.THS = &m
+		data.RawTHS = m.Raw
+	case nmea.TypeGSA:
+		// GPS DOP and active satellites
+		m := s.(nmea.GSA)
+		data.GSA = &m
+		data.RawGSA = m.Raw
+	case nmea.TypeGSV:
+		// GPS Satellites in view
+		m := s.(nmea.GSV)
+		data.GSV = &m
+		data.RawGSV = m.Raw
+		data.Sky.Add(m)
+	case nmea.TypeVTG:
+		// Track Made Good and Ground Speed
+		m := s.(nmea.VTG)
+		data.VTG = &m
+		data.RawVTG = m.Raw
+	default:
+		return fmt.Errorf("%w: unexpected nmea data type %s", ErrUnsupportedSentence, s.DataType())
+	}
+	return nil
+}
+
+// setFix records a new valid fix, with data locked
+func (r *nmeaReader) setFix(m nmea.RMC) {
+	data := r.data
+	prev := data.RMC
+	data.RMC = &m
+	data.LastFix = time.Now()
+	data.Updated = data.LastFix
+	if data.smoother != nil {
+		p := position{Latitude: m.Latitude, Longitude: m.Longitude}
+		// like locationFix, old GGA is the same as none, so an old altitude
+		// isn't smoothed into new positions
+		hasAltitude := false
+		if data.GGA != nil && data.GGAFresh(r.live.Get().StaleAfter) {
+			if altitude, err := altitudeMeters(data.GGA.Altitude, ggaAltitudeUnits(data.GGA)); err == nil {
+				p.Altitude = altitude
+				hasAltitude = true
+			}
+		}
+		smoothed := data.smoother.Update(p, hasAltitude, data.LastFix)
+		data.Smoothed = &smoothed
+		data.smoothedAltitude = hasAltitude
+	}
+	if r.onFix != nil {
+		r.onFix(prev, m)
+	}
+}
+
+// Read reads and parses everything from source, returning when it fails, goes
+// quiet, or ctx is done
+func (r *nmeaReader) Read(ctx context.Context, c Config, source Source) error {
+	data := r.data
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Read(ctx, lines)
+	}()
+	// the mifi sometimes keeps the connection open, and may even send
+	// something, but stops sending sentences, so reconnect if nothing
+	// parses for a while
+	var stale chan struct{}
+	var watchdog *time.Timer
+	if c.StreamWatchdog > 0 {
+		stale = make(chan struct{}, 1)
+		watchdog = time.AfterFunc(c.StreamWatchdog, func() {
+			select {
+			case stale <- struct{}{}:
+			default:
+			}
+		})
+		defer watchdog.Stop()
+	}
+	var logReplayed func(ctx context.Context) error
+	if c.Source == sourceReplay && r.replayLogger != nil {
+		logReplayed = r.replayLogger(c)
+	}
+	var lastCorruptLog, lastSkipLog time.Time
+	for {
+		var line []byte
+		select {
+		case err := <-done:
+			if err == nil {
+				err = fmt.Errorf("%s ended", source)
+			}
+			return err
+		case <-stale:
+			cancel()
+			<-done
+			return fmt.Errorf("no sentences from %s in %s", source, c.StreamWatchdog)
+		case line = <-lines:
+		}
+		line = bytes.Trim(line, "\x00")
+		if string(line) == "" {
+			continue
+		}
+		data.Lock()
+		data.LastLine = time.Now()
+		data.Unlock()
+		if r.capture != nil {
+			if err := r.capture.Write(line); err != nil {
+				log.Printf("error capturing raw NMEA: %v\n", err)
+			}
+		}
+		sentences := splitSentences(line)
+		if len(sentences) == 0 {
+			// let the parser report it as corrupt
+			sentences = [][]byte{line}
+		}
+		for _, sentence := range sentences {
+			if err := r.Parse(sentence); err != nil {
+				if errors.Is(err, ErrCorruptSentence) {
+					// a flaky link can corrupt lots of lines, only log a sample
+					if time.Since(lastCorruptLog) > time.Minute {
+						log.Printf("skipping corrupt sentence %q: %v\n", sentence, err)
+						lastCorruptLog = time.Now()
+					}
+					continue
+				}
+				if errors.Is(err, ErrUnsupportedSentence) && c.Lenient {
+					data.Lock()
+					data.skippedSentences++
+					data.Unlock()
+					if time.Since(lastSkipLog) > time.Minute {
+						log.Printf("skipping sentence %q: %v\n", sentence, err)
+						lastSkipLog = time.Now()
+					}
+					continue
+				}
+				cancel()
+				<-done
+				return fmt.Errorf("failed to parse gps line: %w", err)
+			}
+			if r.onSentence != nil {
+				r.onSentence(sentence)
+			}
+			r.stream.Up()
+			if watchdog != nil {
+				watchdog.Reset(c.StreamWatchdog)
+			}
+			if logReplayed != nil {
+				if err := logReplayed(ctx); skippedFix(err) {
+					log.Printf("skipped queuing, %v\n", err)
+				} else if err != nil {
+					log.Printf("error queuing location: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// readSource reads from a new source for the current config
+func (r *nmeaReader) readSource(ctx context.Context) error {
+	c := r.live.Get()
+	source, err := r.newSource(c)
+	if err != nil {
+		return err
+	}
+	return r.Read(ctx, c, source)
+}
+
+// Run reads from the source until ctx is done, backing off and reconnecting
+// when it fails, and reconnecting to apply new settings when signalled on
+// reconnect
+func (r *nmeaReader) Run(ctx context.Context, reconnect <-chan struct{}) {
+	for {
+		streamCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- r.readSource(streamCtx)
+		}()
+		var err error
+		reloaded := false
+		select {
+		case err = <-done:
+		case <-reconnect:
+			log.Printf("reconnecting to the source with new settings\n")
+			cancel()
+			<-done
+			reloaded = true
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errReplayDone) {
+			log.Println("finished replaying, waiting for a config reload")
+			if r.onReplayDone != nil {
+				r.onReplayDone()
+			}
+			select {
+			case <-reconnect:
+				r.data.Clear()
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if reloaded {
+			r.data.Clear()
+			continue
+		}
+		delay := reconnectMinDelay
+		if err != nil {
+			delay = r.failed(err)
+			r.data.Clear()
+		}
+		select {
+		case <-time.After(delay):
+		case <-reconnect:
+			log.Printf("reconnecting to the source with new settings\n")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
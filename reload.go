@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// liveConfig holds the config, which can be reloaded while running. Only
+// settings tagged `reload:"true"` take effect on reload, the rest need a
+// restart.
+type liveConfig struct {
+	m   sync.RWMutex
+	cfg Config
+	// the command line args, reloads apply them on top of the file and env
+	// again
+	args []string
+}
+
+func newLiveConfig(cfg Config, args []string) *liveConfig {
+	return &liveConfig{cfg: cfg, args: args}
+}
+
+// Get returns the current config
+func (l *liveConfig) Get() Config {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.cfg
+}
+
+// Reload reads the config again, returning the names of the settings that
+// changed. If the new config is invalid the current one is kept. Settings
+// that can't be reloaded keep their current values.
+func (l *liveConfig) Reload() ([]string, error) {
+	next, err := loadConfig(l.args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload config: %w", err)
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	cur := reflect.ValueOf(&l.cfg).Elem()
+	nextValue := reflect.ValueOf(next)
+	var changed []string
+	for i := 0; i < cur.NumField(); i++ {
+		f := cur.Type().Field(i)
+		name, ok := f.Tag.Lookup("config")
+		if !ok || reflect.DeepEqual(cur.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		if f.Tag.Get("reload") != "true" {
+			log.Printf("%s changed, restart to apply it\n", name)
+			continue
+		}
+		cur.Field(i).Set(nextValue.Field(i))
+		changed = append(changed, name)
+	}
+	return changed, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// errReplayDone is returned by the replay source once it's read everything
+var errReplayDone = errors.New("finished replaying")
+
+// replay gaps longer than this, like between separate captures in one file,
+// are skipped rather than waited out
+const replayMaxGap = time.Hour
+
+// replaySource reads NMEA from a file, like a capture, for development and
+// backfilling. Sentences are sent with the timing of their timestamps, scaled
+// by speed, or as fast as possible if speed is 0.
+type replaySource struct {
+	file  string
+	speed float64
+}
+
+func (s *replaySource) String() string {
+	return "replay of " + s.file
+}
+
+// sentenceTime returns the time of day of a sentence, if it has one
+func sentenceTime(line []byte) (time.Duration, bool) {
+	sentences := splitSentences(line)
+	if len(sentences) == 0 {
+		return 0, false
+	}
+	s, err := nmea.Parse(string(sentences[0]))
+	if err != nil {
+		return 0, false
+	}
+	var t nmea.Time
+	switch m := s.(type) {
+	case nmea.RMC:
+		t = m.Time
+	case nmea.GGA:
+		t = m.Time
+	case nmea.ZDA:
+		t = m.Time
+	default:
+		return 0, false
+	}
+	if !t.Valid {
+		return 0, false
+	}
+	return timeOfDay(t), true
+}
+
+func (s *replaySource) Read(ctx context.Context, lines chan<- []byte) error {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := newNMEAScanner(f)
+	var last time.Duration
+	haveLast := false
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if s.speed > 0 {
+			if t, ok := sentenceTime(line); ok {
+				gap := t - last
+				// past midnight
+				if gap < 0 {
+					gap += 24 * time.Hour
+				}
+				if haveLast && gap > 0 && gap < replayMaxGap {
+					select {
+					case <-time.After(time.Duration(float64(gap) / s.speed)):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				last = t
+				haveLast = true
+			}
+		}
+		// the scanner reuses its buffer
+		if !sendLine(ctx, lines, append([]byte(nil), line...)) {
+			return ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.file, err)
+	}
+	return errReplayDone
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// fixRing holds the most recent fixes in memory, overwriting the oldest once
+// it's full
+type fixRing struct {
+	m sync.Mutex
+
+	fixes []currentFix
+	// where the next fix goes
+	next int
+	full bool
+}
+
+func newFixRing(size int) *fixRing {
+	return &fixRing{fixes: make([]currentFix, size)}
+}
+
+func (r *fixRing) Size() int {
+	return len(r.fixes)
+}
+
+func (r *fixRing) Push(fix currentFix) {
+	if len(r.fixes) == 0 {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.fixes[r.next] = fix
+	r.next = (r.next + 1) % len(r.fixes)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to n of the most recent fixes, newest first
+func (r *fixRing) Recent(n int) []currentFix {
+	r.m.Lock()
+	defer r.m.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.fixes)
+	}
+	if n < 0 || n > count {
+		n = count
+	}
+	recent := make([]currentFix, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, r.fixes[(r.next-i+len(r.fixes))%len(r.fixes)])
+	}
+	return recent
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// longest line we'll buffer while waiting for its end, NMEA sentences are
+// meant to be at most 82 characters but some receivers go over
+const maxLineLength = 1024
+
+// scanNMEALines is a bufio.SplitFunc for NMEA streams, splitting on CR, LF or
+// CRLF and holding on to partial lines until the rest arrives, as sentences
+// can be split across reads. Lines too long to be NMEA are passed on in
+// pieces, to be skipped as corrupt.
+func scanNMEALines(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	// skip the line endings left from the last line
+	for start < len(data) && (data[start] == '\r' || data[start] == '\n') {
+		start++
+	}
+	if i := bytes.IndexAny(data[start:], "\r\n"); i >= 0 {
+		return start + i + 1, data[start : start+i], nil
+	}
+	if len(data)-start >= maxLineLength || (atEOF && len(data) > start) {
+		return len(data), data[start:], nil
+	}
+	// request more data
+	return start, nil, nil
+}
+
+// newNMEAScanner scans NMEA lines from r
+func newNMEAScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 2*maxLineLength)
+	scanner.Split(scanNMEALines)
+	return scanner
+}
+
+// checkSentence checks that a sentence is complete and its checksum matches,
+// before trying to parse it
+func checkSentence(sentence []byte) error {
+	if len(sentence) == 0 || sentence[0] != '$' {
+		return fmt.Errorf("%w: missing $", ErrCorruptSentence)
+	}
+	sep := bytes.LastIndexByte(sentence, '*')
+	if sep == -1 {
+		return fmt.Errorf("%w: missing checksum", ErrCorruptSentence)
+	}
+	want, err := hex.DecodeString(string(bytes.TrimSpace(sentence[sep+1:])))
+	if err != nil || len(want) != 1 {
+		return fmt.Errorf("%w: invalid checksum %q", ErrCorruptSentence, sentence[sep+1:])
+	}
+	var sum byte
+	for _, c := range sentence[1:sep] {
+		sum ^= c
+	}
+	if sum != want[0] {
+		return fmt.Errorf("%w: checksum mismatch [%02X != %02X]", ErrCorruptSentence, sum, want[0])
+	}
+	return nil
+}
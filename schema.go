@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// checkTimestampColumns checks that gps_logs stores timestamps with a time
+// zone. We always write UTC, but plain timestamp columns are easy to misread
+// as local time when querying.
+func checkTimestampColumns(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT column_name, data_type FROM information_schema.columns WHERE table_name = 'gps_logs' AND column_name IN ('logged_at', 'gps_timestamp')`)
+	if err != nil {
+		return fmt.Errorf("failed to check gps_logs columns: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return fmt.Errorf("failed to check gps_logs columns: %w", err)
+		}
+		if dataType != "timestamp with time zone" {
+			return fmt.Errorf("gps_logs.%s is %s rather than timestamptz, times are stored as UTC", column, dataType)
+		}
+	}
+	return rows.Err()
+}
+
+type queuedOp struct {
+	query string
+	args  []interface{}
+}
+
+// logInsert builds an insert of a row into gps_logs, since which columns are
+// written depends on config
+type logInsert struct {
+	columns []string
+	values  []string
+	args    []interface{}
+}
+
+func (l *logInsert) add(column string, arg interface{}) {
+	l.args = append(l.args, arg)
+	l.columns = append(l.columns, column)
+	l.values = append(l.values, fmt.Sprintf("$%d", len(l.args)))
+}
+
+// addGeography adds a geography column, passed as EWKT
+func (l *logInsert) addGeography(column string, ewkt string) {
+	l.add(column, ewkt)
+	l.values[len(l.values)-1] = fmt.Sprintf("ST_GeographyFromText(%s)", l.values[len(l.values)-1])
+}
+
+func (l *logInsert) op() queuedOp {
+	return queuedOp{
+		query: fmt.Sprintf("INSERT INTO gps_logs(%s) VALUES(%s)", strings.Join(l.columns, ", "), strings.Join(l.values, ", ")),
+		args:  l.args,
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// serialSource reads NMEA straight from a receiver on a serial port, like a
+// USB GPS
+type serialSource struct {
+	device string
+	baud   int
+	// how long the receiver can go quiet before we consider it dead
+	readTimeout time.Duration
+}
+
+func (s *serialSource) String() string {
+	return fmt.Sprintf("%s at %d baud", s.device, s.baud)
+}
+
+func (s *serialSource) Read(ctx context.Context, lines chan<- []byte) error {
+	f, err := openSerial(s.device, s.baud)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readConnLines(ctx, f, s.readTimeout, lines, nil)
+}
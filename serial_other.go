@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// serialBaudRates are the supported baud rates
+var serialBaudRates = map[int]uint32{
+	4800:   4800,
+	9600:   9600,
+	19200:  19200,
+	38400:  38400,
+	57600:  57600,
+	115200: 115200,
+	230400: 230400,
+}
+
+func openSerial(device string, baud int) (*os.File, error) {
+	return nil, errors.New("the serial source is only supported on Linux")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// exportSummary tracks what was exported
+type exportSummary struct {
+	Count       int
+	First, Last time.Time
+}
+
+func (s *exportSummary) add(l logRow) {
+	s.Count++
+	if !l.Timestamp.Valid {
+		return
+	}
+	if s.First.IsZero() || l.Timestamp.Time.Before(s.First) {
+		s.First = l.Timestamp.Time
+	}
+	if l.Timestamp.Time.After(s.Last) {
+		s.Last = l.Timestamp.Time
+	}
+}
+
+func (s exportSummary) String() string {
+	if s.First.IsZero() {
+		return fmt.Sprintf("%d points", s.Count)
+	}
+	return fmt.Sprintf("%d points from %s to %s (%s)", s.Count, s.First.Format(time.RFC3339), s.Last.Format(time.RFC3339), s.Last.Sub(s.First))
+}
+
+// exporters write every row to w in some file format
+var exporters = map[string]func(w io.Writer, rows *sql.Rows, summary *exportSummary) error{
+	"gpx": exportGPX,
+	"csv": exportCSV,
+}
+
+type gpxPoint struct {
+	XMLName   xml.Name `xml:"trkpt"`
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation float64  `xml:"ele"`
+	Time      string   `xml:"time,omitempty"`
+}
+
+// exportGPX writes points as a single GPX track, one point at a time so large
+// exports don't need to fit in memory
+func exportGPX(w io.Writer, rows *sql.Rows, summary *exportSummary) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, xml.Header)
+	fmt.Fprint(bw, `<gpx version="1.1" creator="mifi-gps" xmlns="http://www.topografix.com/GPX/1/1">`+"\n")
+	fmt.Fprint(bw, "<trk><trkseg>\n")
+	enc := xml.NewEncoder(bw)
+	for rows.Next() {
+		l, err := scanLog(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		summary.add(l)
+		p := gpxPoint{
+			Latitude:  l.Point.Y,
+			Longitude: l.Point.X,
+			Elevation: l.Point.Z,
+		}
+		if l.Timestamp.Valid {
+			p.Time = l.Timestamp.Time.UTC().Format(time.RFC3339)
+		}
+		if err := enc.Encode(p); err != nil {
+			return fmt.Errorf("failed to write point: %w", err)
+		}
+		fmt.Fprint(bw, "\n")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	fmt.Fprint(bw, "</trkseg></trk>\n</gpx>\n")
+	return bw.Flush()
+}
+
+// exportCSV writes points as CSV with a header row
+func exportCSV(w io.Writer, rows *sql.Rows, summary *exportSummary) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"logged_at", "gps_timestamp", "latitude", "longitude", "altitude", "speed", "course"}); err != nil {
+		return err
+	}
+	for rows.Next() {
+		l, err := scanLog(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		summary.add(l)
+		record := []string{
+			l.LoggedAt.Format(time.RFC3339),
+			"",
+			strconv.FormatFloat(l.Point.Y, 'f', -1, 64),
+			strconv.FormatFloat(l.Point.X, 'f', -1, 64),
+			strconv.FormatFloat(l.Point.Z, 'f', -1, 64),
+			"",
+			"",
+		}
+		if l.Timestamp.Valid {
+			record[1] = l.Timestamp.Time.Format(time.RFC3339)
+		}
+		if l.Speed.Valid {
+			record[5] = strconv.FormatFloat(l.Speed.Float64, 'f', -1, 64)
+		}
+		if l.Course.Valid {
+			record[6] = strconv.FormatFloat(l.Course.Float64, 'f', -1, 64)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// runExport handles the export command, streaming rows straight to the output
+// so huge exports don't have to fit in memory
+func runExport(db *sql.DB, limits queryLimits, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "file to write to (default stdout)")
+	fs.IntVar(&limits.maxRows, "max", limits.maxRows, "fail rather than export more than this many points, 0 for no limit")
+	maxRange := fs.String("max-range", "", "fail rather than export points spanning longer than this, 0 for no limit (default MIFI_GPS_MAXQUERYRANGE)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *maxRange != "" {
+		d, err := parseDuration(*maxRange)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid max range %q", *maxRange)
+		}
+		limits.maxRange = d
+	}
+	if limits.maxRows < 0 {
+		return fmt.Errorf("invalid max %d", limits.maxRows)
+	}
+	args = fs.Args()
+	if len(args) != 1 && len(args) != 3 {
+		return errUsage
+	}
+	export, ok := exporters[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", args[0])
+	}
+
+	query := selectLogs + ` ORDER BY logged_at`
+	var queryArgs []interface{}
+	if len(args) == 3 {
+		from, to, err := parseRange(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if err := limits.checkRange(from, to); err != nil {
+			return err
+		}
+		query = selectLogs + ` AND logged_at BETWEEN $1 AND $2 ORDER BY logged_at`
+		queryArgs = []interface{}{from, to}
+	}
+	// check up front so we don't leave a partial export
+	if err := limits.check(db, query, queryArgs...); err != nil {
+		return err
+	}
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query points: %w", err)
+	}
+	defer rows.Close()
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *output != "" {
+		f, err = os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var summary exportSummary
+	err = export(w, rows, &summary)
+	if err == nil && summary.Count == 0 {
+		err = errNoLogs
+	}
+	if err != nil {
+		if f != nil {
+			os.Remove(f.Name())
+		}
+		return err
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write export file: %w", err)
+		}
+	}
+	// stdout may be the export itself, so report on stderr
+	fmt.Fprintf(os.Stderr, "exported %s\n", summary)
+	return nil
+}
@@ -0,0 +1,287 @@
+// Command server queries the GPS history logged by mifi-gps.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+	_ "github.com/lib/pq"
+)
+
+const usage = `usage: server <command> [arguments]
+
+commands:
+  latest                 print the most recently logged point
+  last N                 print the last N logged points
+  range FROM TO          print points logged between two RFC 3339 timestamps
+  export [-o FILE] [-max N] [-max-range D] gpx|csv [FROM TO]
+                         write logged points, optionally only those between two
+                         RFC 3339 timestamps, in the given format to FILE
+                         (default stdout), failing if there are more than N or
+                         they span more than D
+
+The database connection string is read from MIFI_GPS_DBCONNSTR. last, range
+and export fail rather than read more than MIFI_GPS_MAXQUERYROWS points
+(default 100000), and range and export fail rather than read a range longer
+than MIFI_GPS_MAXQUERYRANGE (default 31d), like the logger's query APIs, 0 for
+no limit.
+`
+
+// logRow is a single row of gps_logs
+type logRow struct {
+	LoggedAt  time.Time
+	Timestamp sql.NullTime
+	Point     postgis.PointZS
+	Speed     sql.NullFloat64
+	Course    sql.NullFloat64
+}
+
+// selectLogs selects the columns scanned by scanLog, points without a
+// geometry aren't useful to us so they're skipped. 2D points get a zero
+// altitude.
+const selectLogs = `SELECT logged_at, gps_timestamp, ST_Force3D(gps_geometry::geometry), gps_speed, gps_course FROM gps_logs WHERE gps_geometry IS NOT NULL`
+
+func scanLog(rows *sql.Rows) (logRow, error) {
+	var l logRow
+	err := rows.Scan(&l.LoggedAt, &l.Timestamp, &l.Point, &l.Speed, &l.Course)
+	return l, err
+}
+
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+func formatNullFloat(f sql.NullFloat64) string {
+	if !f.Valid {
+		return "-"
+	}
+	return strconv.FormatFloat(f.Float64, 'f', 2, 64)
+}
+
+// errNoLogs is returned when a query matches nothing, so scripts can tell an
+// empty result from success
+var errNoLogs = errors.New("no GPS logs found")
+
+// printLogs prints rows as a table
+func printLogs(w io.Writer, rows *sql.Rows) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOGGED AT\tGPS TIME\tLATITUDE\tLONGITUDE\tALTITUDE\tSPEED\tCOURSE")
+	count := 0
+	for rows.Next() {
+		count++
+		l, err := scanLog(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%f\t%f\t%.1f\t%s\t%s\n",
+			l.LoggedAt.Format(time.RFC3339),
+			formatNullTime(l.Timestamp),
+			l.Point.Y,
+			l.Point.X,
+			l.Point.Z,
+			formatNullFloat(l.Speed),
+			formatNullFloat(l.Course),
+		)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	if count == 0 {
+		return errNoLogs
+	}
+	return tw.Flush()
+}
+
+func parseRange(fromArg, toArg string) (time.Time, time.Time, error) {
+	from, err := time.Parse(time.RFC3339, fromArg)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from time: %w", err)
+	}
+	to, err := time.Parse(time.RFC3339, toArg)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to time: %w", err)
+	}
+	return from, to, nil
+}
+
+// parseDuration is like time.ParseDuration, but also accepts a whole number of
+// days, like "31d", as the logger does
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// queryLimits bound how much a single command reads, like the logger's query
+// APIs, with the same defaults
+type queryLimits struct {
+	// longest time range, 0 for no limit
+	maxRange time.Duration
+	// most points, 0 for no limit
+	maxRows int
+}
+
+// queryLimitsFromEnv reads limits from MIFI_GPS_MAXQUERYRANGE and
+// MIFI_GPS_MAXQUERYROWS, like the logger does
+func queryLimitsFromEnv() (queryLimits, error) {
+	limits := queryLimits{maxRange: 31 * 24 * time.Hour, maxRows: 100000}
+	if v := os.Getenv("MIFI_GPS_MAXQUERYRANGE"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d < 0 {
+			return limits, fmt.Errorf("invalid MIFI_GPS_MAXQUERYRANGE %q", v)
+		}
+		limits.maxRange = d
+	}
+	if v := os.Getenv("MIFI_GPS_MAXQUERYROWS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid MIFI_GPS_MAXQUERYROWS %q", v)
+		}
+		limits.maxRows = n
+	}
+	return limits, nil
+}
+
+// check counts the points query would return up front, so nothing is written
+// when it's over the limits. Without a time range in the query, the range
+// checked is the one the points span.
+func (l queryLimits) check(db *sql.DB, query string, args ...interface{}) error {
+	if l.maxRange <= 0 && l.maxRows <= 0 {
+		return nil
+	}
+	var count int
+	var first, last sql.NullTime
+	if err := db.QueryRow(`SELECT count(*), min(logged_at), max(logged_at) FROM (`+query+`) q`, args...).Scan(&count, &first, &last); err != nil {
+		return fmt.Errorf("failed to count points: %w", err)
+	}
+	if l.maxRows > 0 && count > l.maxRows {
+		return fmt.Errorf("%d points is more than the max of %d, use a shorter range", count, l.maxRows)
+	}
+	if l.maxRange > 0 && first.Valid && last.Valid && last.Time.Sub(first.Time) > l.maxRange {
+		return fmt.Errorf("points span more than the max range of %s, use a shorter range", l.maxRange)
+	}
+	return nil
+}
+
+// checkRange checks an explicit time range before anything is queried
+func (l queryLimits) checkRange(from, to time.Time) error {
+	if l.maxRange > 0 && to.Sub(from) > l.maxRange {
+		return fmt.Errorf("time range can be at most %s", l.maxRange)
+	}
+	return nil
+}
+
+func run(db *sql.DB, limits queryLimits, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "latest":
+		if len(args) != 1 {
+			return errUsage
+		}
+		rows, err := db.Query(selectLogs + ` ORDER BY logged_at DESC LIMIT 1`)
+		if err != nil {
+			return fmt.Errorf("failed to query latest point: %w", err)
+		}
+		defer rows.Close()
+		return printLogs(os.Stdout, rows)
+	case "last":
+		if len(args) != 2 {
+			return errUsage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+		if limits.maxRows > 0 && n > limits.maxRows {
+			return fmt.Errorf("count can be at most %d", limits.maxRows)
+		}
+		// newest N, printed oldest first
+		rows, err := db.Query(`SELECT * FROM (`+selectLogs+` ORDER BY logged_at DESC LIMIT $1) l ORDER BY logged_at`, n)
+		if err != nil {
+			return fmt.Errorf("failed to query last points: %w", err)
+		}
+		defer rows.Close()
+		return printLogs(os.Stdout, rows)
+	case "range":
+		if len(args) != 3 {
+			return errUsage
+		}
+		from, to, err := parseRange(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if err := limits.checkRange(from, to); err != nil {
+			return err
+		}
+		query := selectLogs + ` AND logged_at BETWEEN $1 AND $2 ORDER BY logged_at`
+		if err := limits.check(db, query, from, to); err != nil {
+			return err
+		}
+		rows, err := db.Query(query, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to query range: %w", err)
+		}
+		defer rows.Close()
+		return printLogs(os.Stdout, rows)
+	case "export":
+		return runExport(db, limits, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+var errUsage = fmt.Errorf("invalid usage")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	connStr := os.Getenv("MIFI_GPS_DBCONNSTR")
+	if connStr == "" {
+		fmt.Fprintln(os.Stderr, "missing db connection string in env var MIFI_GPS_DBCONNSTR")
+		os.Exit(1)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	limits, err := queryLimitsFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := run(db, limits, flag.Args()); err != nil {
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Signal K (https://signalk.org/specification/1.7.0/doc/) deltas, so boat
+// installations can feed our position into their Signal K server
+
+const signalKVersion = "1.7.0"
+
+type signalKDelta struct {
+	Context string          `json:"context"`
+	Updates []signalKUpdate `json:"updates"`
+}
+
+type signalKUpdate struct {
+	Source    signalKSource  `json:"source"`
+	Timestamp string         `json:"timestamp"`
+	Values    []signalKValue `json:"values"`
+}
+
+type signalKSource struct {
+	Label string `json:"label"`
+}
+
+type signalKValue struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type signalKPosition struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// signalKDeltaFrom returns a delta for a fix, in Signal K's SI units
+func signalKDeltaFrom(fix currentFix) signalKDelta {
+	values := []signalKValue{
+		{"navigation.position", signalKPosition{fix.Latitude, fix.Longitude, fix.Altitude}},
+		{"navigation.courseOverGroundTrue", fix.Course * math.Pi / 180},
+		{"navigation.speedOverGround", fix.Speed * knotsToMPS},
+	}
+	if fix.Heading != nil {
+		values = append(values, signalKValue{"navigation.headingTrue", *fix.Heading * math.Pi / 180})
+	}
+	if fix.Satellites != nil {
+		values = append(values, signalKValue{"navigation.gnss.satellites", *fix.Satellites})
+	}
+	if fix.HDOP != nil {
+		values = append(values, signalKValue{"navigation.gnss.horizontalDilution", *fix.HDOP})
+	}
+	return signalKDelta{
+		Context: "vessels.self",
+		Updates: []signalKUpdate{{
+			Source:    signalKSource{Label: "mifi-gps"},
+			Timestamp: fix.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+			Values:    values,
+		}},
+	}
+}
+
+// signalKHub passes deltas on to connected clients
+type signalKHub struct {
+	*lineHub
+}
+
+func newSignalKHub() *signalKHub {
+	return &signalKHub{newLineHub(16)}
+}
+
+// Publish sends a fix to every client as a delta
+func (h *signalKHub) Publish(fix currentFix) {
+	if h.Clients() == 0 {
+		return
+	}
+	delta, err := json.Marshal(signalKDeltaFrom(fix))
+	if err != nil {
+		log.Printf("error encoding Signal K delta: %v\n", err)
+		return
+	}
+	h.Send(delta)
+}
+
+// serveSignalK streams newline delimited deltas to TCP clients on addr, like
+// Signal K server's TCP data connections expect
+func serveSignalK(addr string, hub *signalKHub) error {
+	return serveLines(addr, "Signal K deltas", "\n", hub.lineHub)
+}
+
+// signalKDiscoveryHandler tells Signal K clients where the stream is
+func signalKDiscoveryHandler(rw http.ResponseWriter, r *http.Request) {
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	writeJSON(rw, map[string]interface{}{
+		"endpoints": map[string]interface{}{
+			"v1": map[string]string{
+				"version":    signalKVersion,
+				"signalk-ws": scheme + "://" + r.Host + "/signalk/v1/stream",
+			},
+		},
+		"server": map[string]string{"id": "mifi-gps", "version": "1.0.0"},
+	})
+}
+
+var signalKUpgrader = websocket.Upgrader{
+	// Signal K servers and apps aren't browsers on our origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// signalKStreamHandler streams deltas over a WebSocket, after Signal K's hello
+func signalKStreamHandler(hub *signalKHub) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := signalKUpgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			// the upgrader has already responded
+			return
+		}
+		defer conn.Close()
+		hello := map[string]interface{}{
+			"name":      "mifi-gps",
+			"version":   signalKVersion,
+			"self":      "vessels.self",
+			"roles":     []string{"master"},
+			"timestamp": time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		}
+		if err := conn.WriteJSON(hello); err != nil {
+			return
+		}
+		deltas := hub.subscribe()
+		defer hub.unsubscribe(deltas)
+		// subscriptions sent by clients are ignored, reading only notices
+		// when they've gone
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case <-closed:
+				return
+			case delta := <-deltas:
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteMessage(websocket.TextMessage, delta); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// the default simulated route, a loop around Seattle's Green Lake
+const defaultSimRoute = "47.6833,-122.3444;47.6862,-122.3375;47.6810,-122.3299;47.6752,-122.3338;47.6765,-122.3420"
+
+// simulated satellites in view, as PRN, elevation and azimuth
+var simSatellites = [][3]int{
+	{2, 67, 48}, {5, 42, 131}, {12, 28, 210}, {15, 55, 300},
+	{18, 15, 80}, {21, 33, 170}, {25, 71, 250}, {29, 10, 20},
+}
+
+// simRoute is a looped route of waypoints, followed at a constant speed
+type simRoute struct {
+	points []position
+	// cumulative distance to each point, and the total including back to the start
+	dist  []float64
+	total float64
+}
+
+func parseSimRoute(s string) (*simRoute, error) {
+	r := &simRoute{}
+	for _, p := range strings.Split(s, ";") {
+		lat, lon, err := parseLatLon(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		r.points = append(r.points, position{Latitude: lat, Longitude: lon})
+	}
+	for i, p := range r.points {
+		r.dist = append(r.dist, r.total)
+		next := r.points[(i+1)%len(r.points)]
+		r.total += haversine(p.Latitude, p.Longitude, next.Latitude, next.Longitude)
+	}
+	if r.total == 0 {
+		return nil, errors.New("route needs at least two different points")
+	}
+	return r, nil
+}
+
+// at returns the position and course after travelling d meters
+func (r *simRoute) at(d float64) (position, float64) {
+	d = math.Mod(d, r.total)
+	i := len(r.points) - 1
+	for d < r.dist[i] {
+		i--
+	}
+	from, to := r.points[i], r.points[(i+1)%len(r.points)]
+	leg := haversine(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	f := 0.0
+	if leg > 0 {
+		f = (d - r.dist[i]) / leg
+	}
+	return position{
+		Latitude:  from.Latitude + (to.Latitude-from.Latitude)*f,
+		Longitude: from.Longitude + (to.Longitude-from.Longitude)*f,
+	}, bearing(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+}
+
+// nmeaSentence adds the $ and checksum to a sentence's body
+func nmeaSentence(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, sum)
+}
+
+// nmeaLatLon formats a position as NMEA's degrees and decimal minutes
+func nmeaLatLon(lat, lon float64) string {
+	latHemi, lonHemi := "N", "E"
+	if lat < 0 {
+		latHemi, lat = "S", -lat
+	}
+	if lon < 0 {
+		lonHemi, lon = "W", -lon
+	}
+	latDeg, lonDeg := math.Floor(lat), math.Floor(lon)
+	return fmt.Sprintf("%02.0f%07.4f,%s,%03.0f%07.4f,%s", latDeg, (lat-latDeg)*60, latHemi, lonDeg, (lon-lonDeg)*60, lonHemi)
+}
+
+// simSentences returns the sentences a receiver would send for a fix
+func simSentences(t time.Time, p position, speed, course float64) []string {
+	t = t.UTC()
+	hms := t.Format("150405.00")
+	latLon := nmeaLatLon(p.Latitude, p.Longitude)
+	sentences := []string{
+		nmeaSentence(fmt.Sprintf("GPRMC,%s,A,%s,%.1f,%.1f,%s,,,A", hms, latLon, speed, course, t.Format("020106"))),
+		nmeaSentence(fmt.Sprintf("GPGGA,%s,%s,1,%02d,0.9,%.1f,M,-17.0,M,,", hms, latLon, len(simSatellites), p.Altitude)),
+		nmeaSentence("GPGSA,A,3,02,05,12,15,18,21,25,29,,,,,1.6,0.9,1.3"),
+		nmeaSentence(fmt.Sprintf("GPVTG,%.1f,T,,M,%.1f,N,%.1f,K,A", course, speed, speed*knotsToKPH)),
+	}
+	// four satellites per GSV sentence
+	count := (len(simSatellites) + 3) / 4
+	for i := 0; i < count; i++ {
+		body := fmt.Sprintf("GPGSV,%d,%d,%02d", count, i+1, len(simSatellites))
+		for _, sat := range simSatellites[i*4 : int(math.Min(float64(i*4+4), float64(len(simSatellites))))] {
+			body += fmt.Sprintf(",%02d,%02d,%03d,%02d", sat[0], sat[1], sat[2], 30+sat[1]/5)
+		}
+		sentences = append(sentences, nmeaSentence(body))
+	}
+	return sentences
+}
+
+// runSimulate serves simulated NMEA like the mifi does, as a bare stream
+// without HTTP headers, so it works with both the mifi and tcp sources
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	listen := fs.String("listen", ":"+defaultMifiPort, "address to serve the NMEA stream on")
+	routeArg := fs.String("route", defaultSimRoute, "route to follow and loop, as lat,lon points separated by semicolons")
+	speed := fs.Float64("speed", 20, "speed in knots")
+	altitude := fs.Float64("altitude", 60, "altitude in meters")
+	interval := fs.Duration("interval", time.Second, "time between fixes")
+	fs.Parse(args)
+	route, err := parseSimRoute(*routeArg)
+	if err != nil {
+		return fmt.Errorf("invalid route: %w", err)
+	}
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("simulating a %.0fm route at %.1f knots on %s\n", route.total, *speed, l.Addr())
+	start := time.Now()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			log.Printf("streaming to %s\n", conn.RemoteAddr())
+			// the mifi ignores requests, but they still need reading
+			go io.Copy(io.Discard, conn)
+			w := bufio.NewWriter(conn)
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				p, course := route.at(now.Sub(start).Seconds() * *speed * knotsToMPS)
+				p.Altitude = *altitude
+				for _, s := range simSentences(now, p, *speed, course) {
+					fmt.Fprintf(w, "%s\r\n", s)
+				}
+				if err := w.Flush(); err != nil {
+					log.Printf("stopped streaming to %s: %v\n", conn.RemoteAddr(), err)
+					return
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// position is a single 3D fix
+type position struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+// smoother filters a stream of positions to reduce jitter
+type smoother interface {
+	// Update adds a new raw position and returns the smoothed position.
+	// Without an altitude, like when GGA has stopped, only latitude and
+	// longitude are smoothed and the returned altitude is meaningless.
+	Update(p position, hasAltitude bool, t time.Time) position
+	// Reset forgets all history, e.g. after losing the GPS stream
+	Reset()
+}
+
+// newSmoother builds the named smoother, returning nil if smoothing is off
+func newSmoother(kind string, window int, noise, speed float64) (smoother, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "average":
+		if window < 1 {
+			return nil, fmt.Errorf("smoothing window must be at least 1")
+		}
+		return &movingAverage{window: window}, nil
+	case "kalman":
+		if noise <= 0 || speed <= 0 {
+			return nil, fmt.Errorf("kalman noise and speed must be positive")
+		}
+		return &kalmanFilter{measurementVariance: noise * noise, processVariance: speed * speed}, nil
+	default:
+		return nil, fmt.Errorf("unknown smoothing %q", kind)
+	}
+}
+
+// movingAverage averages the last window positions, and the last window
+// altitudes
+type movingAverage struct {
+	window    int
+	recent    []position
+	altitudes []float64
+}
+
+func (m *movingAverage) Update(p position, hasAltitude bool, _ time.Time) position {
+	m.recent = append(m.recent, p)
+	if len(m.recent) > m.window {
+		m.recent = m.recent[len(m.recent)-m.window:]
+	}
+	var sum position
+	for _, r := range m.recent {
+		sum.Latitude += r.Latitude
+		sum.Longitude += r.Longitude
+	}
+	n := float64(len(m.recent))
+	smoothed := position{
+		Latitude:  sum.Latitude / n,
+		Longitude: sum.Longitude / n,
+	}
+	if !hasAltitude {
+		return smoothed
+	}
+	m.altitudes = append(m.altitudes, p.Altitude)
+	if len(m.altitudes) > m.window {
+		m.altitudes = m.altitudes[len(m.altitudes)-m.window:]
+	}
+	for _, a := range m.altitudes {
+		smoothed.Altitude += a
+	}
+	smoothed.Altitude /= float64(len(m.altitudes))
+	return smoothed
+}
+
+func (m *movingAverage) Reset() {
+	m.recent = nil
+	m.altitudes = nil
+}
+
+// kalmanFilter runs an independent 1D Kalman filter on each axis, assuming the
+// receiver stays put between fixes but may drift at up to roughly the
+// configured speed.
+//
+// Variances are in square meters. The state is in degrees for lat/lon, but
+// only the ratio of the variances matters for the gain so the units don't
+// need converting.
+type kalmanFilter struct {
+	measurementVariance float64
+	processVariance     float64
+
+	state    position
+	variance float64
+	last     time.Time
+	// whether state has an altitude yet
+	hasAltitude bool
+}
+
+func (k *kalmanFilter) Update(p position, hasAltitude bool, t time.Time) position {
+	if k.last.IsZero() {
+		k.state = p
+		k.variance = k.measurementVariance
+		k.last = t
+		k.hasAltitude = hasAltitude
+		return p
+	}
+	dt := t.Sub(k.last).Seconds()
+	if dt > 0 {
+		k.variance += dt * k.processVariance
+		k.last = t
+	}
+	gain := k.variance / (k.variance + k.measurementVariance)
+	k.state.Latitude += gain * (p.Latitude - k.state.Latitude)
+	k.state.Longitude += gain * (p.Longitude - k.state.Longitude)
+	switch {
+	case !hasAltitude:
+	case !k.hasAltitude:
+		// the first altitude, like when GGA comes back
+		k.state.Altitude = p.Altitude
+		k.hasAltitude = true
+	default:
+		k.state.Altitude += gain * (p.Altitude - k.state.Altitude)
+	}
+	k.variance *= 1 - gain
+	return k.state
+}
+
+func (k *kalmanFilter) Reset() {
+	k.last = time.Time{}
+	k.hasAltitude = false
+}
+
+// ewkt formats the position as PostGIS extended well-known text
+func (p position) ewkt() string {
+	return fmt.Sprintf("SRID=4326;POINTZ(%f %f %f)", p.Longitude, p.Latitude, p.Altitude)
+}
+
+// ewkt2D leaves out altitude, for when we don't have it
+func (p position) ewkt2D() string {
+	return fmt.Sprintf("SRID=4326;POINT(%f %f)", p.Longitude, p.Latitude)
+}
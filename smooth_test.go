@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func closeTo(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestMovingAverage(t *testing.T) {
+	s, err := newSmoother("average", 3, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		in       position
+		expected position
+	}{
+		{position{1, 10, 100}, position{1, 10, 100}},
+		{position{2, 20, 200}, position{1.5, 15, 150}},
+		{position{3, 30, 300}, position{2, 20, 200}},
+		// the first falls out of the window
+		{position{4, 40, 400}, position{3, 30, 300}},
+	}
+	for i, test := range tests {
+		p := s.Update(test.in, true, now)
+		if p != test.expected {
+			t.Errorf("update %d: expected %+v, got %+v", i, test.expected, p)
+		}
+	}
+
+	// without an altitude, latitude and longitude carry on and the altitude
+	// window is left alone
+	p := s.Update(position{5, 50, 0}, false, now)
+	if p.Latitude != 4 || p.Longitude != 40 {
+		t.Errorf("expected 4, 40 without altitude, got %+v", p)
+	}
+	p = s.Update(position{6, 60, 600}, true, now)
+	if p != (position{5, 50, (300 + 400 + 600) / 3.0}) {
+		t.Errorf("expected altitude averaged over the last altitudes, got %+v", p)
+	}
+
+	s.Reset()
+	if p := s.Update(position{7, 70, 700}, true, now); p != (position{7, 70, 700}) {
+		t.Errorf("expected no history after reset, got %+v", p)
+	}
+}
+
+func TestKalmanConvergesWhenStationary(t *testing.T) {
+	s, err := newSmoother("kalman", 0, 10, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := position{45, -122, 100}
+	now := time.Now()
+	// start off by a lot, then jitter either side of where we really are
+	s.Update(position{45.001, -122.001, 150}, true, now)
+	var p position
+	for i := 1; i <= 200; i++ {
+		jitter := 0.0001
+		if i%2 == 0 {
+			jitter = -jitter
+		}
+		p = s.Update(position{
+			actual.Latitude + jitter,
+			actual.Longitude + jitter,
+			actual.Altitude + jitter*10000,
+		}, true, now.Add(time.Duration(i)*time.Second))
+	}
+	if !closeTo(p.Latitude, actual.Latitude, 0.00005) || !closeTo(p.Longitude, actual.Longitude, 0.00005) || !closeTo(p.Altitude, actual.Altitude, 0.5) {
+		t.Errorf("expected to converge on %+v, got %+v", actual, p)
+	}
+}
+
+func TestKalmanAltitude(t *testing.T) {
+	s, err := newSmoother("kalman", 0, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.Update(position{45, -122, 0}, false, now)
+	// the first altitude is taken as is rather than smoothed from nothing
+	p := s.Update(position{45, -122, 100}, true, now.Add(time.Second))
+	if p.Altitude != 100 {
+		t.Errorf("expected the first altitude as is, got %v", p.Altitude)
+	}
+	p = s.Update(position{45, -122, 0}, false, now.Add(2*time.Second))
+	if p.Altitude != 100 {
+		t.Errorf("expected the altitude to be kept without a new one, got %v", p.Altitude)
+	}
+}
+
+func TestKalmanReset(t *testing.T) {
+	s, err := newSmoother("kalman", 0, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.Update(position{45, -122, 100}, true, now)
+	s.Update(position{45.001, -122.001, 110}, true, now.Add(time.Second))
+	s.Reset()
+	in := position{10, 20, 30}
+	if p := s.Update(in, true, now.Add(2*time.Second)); p != in {
+		t.Errorf("expected no history after reset, got %+v", p)
+	}
+	// after reset without an altitude, the next altitude starts fresh
+	s.Reset()
+	s.Update(in, false, now.Add(3*time.Second))
+	if p := s.Update(position{10, 20, 500}, true, now.Add(4*time.Second)); p.Altitude != 500 {
+		t.Errorf("expected the first altitude after reset as is, got %v", p.Altitude)
+	}
+}
+
+func TestNewSmoother(t *testing.T) {
+	if s, err := newSmoother("", 0, 0, 0); err != nil || s != nil {
+		t.Errorf("expected no smoother, got %v, %v", s, err)
+	}
+	for _, test := range []struct {
+		kind         string
+		window       int
+		noise, speed float64
+	}{
+		{"average", 0, 0, 0},
+		{"kalman", 0, 0, 1},
+		{"kalman", 0, 1, 0},
+		{"unknown", 1, 1, 1},
+	} {
+		if _, err := newSmoother(test.kind, test.window, test.noise, test.speed); err == nil {
+			t.Errorf("expected an error for %+v", test)
+		}
+	}
+}
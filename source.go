@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// sources of NMEA data
+const (
+	sourceMifi   = "mifi"
+	sourceGPSD   = "gpsd"
+	sourceSerial = "serial"
+	sourceUDP    = "udp"
+	sourceTCP    = "tcp"
+	sourceReplay = "replay"
+)
+
+// sourceSettings are the settings used by sources, changing any of them
+// means reconnecting
+var sourceSettings = map[string]bool{
+	"source":          true,
+	"addr":            true,
+	"read_timeout":    true,
+	"stream_watchdog": true,
+	"gpsd_server":     true,
+	"serial_device":   true,
+	"serial_baud":     true,
+	"udp_listen":      true,
+	"tcp_server":      true,
+	"replay_file":     true,
+	"replay_speed":    true,
+}
+
+// Source reads NMEA data from somewhere, like the mifi's stream. Sources
+// only deliver lines, parsing them is left to the caller so every source is
+// handled the same way.
+type Source interface {
+	// Read sends each line received to lines until it fails or ctx is done.
+	// Lines can hold several sentences, or be noise.
+	Read(ctx context.Context, lines chan<- []byte) error
+	// String describes the source for logs
+	String() string
+}
+
+// newSource creates the source chosen in c
+func newSource(c Config) (Source, error) {
+	switch c.Source {
+	case sourceMifi:
+		server, err := mifiURL(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return &mifiSource{url: server, readTimeout: c.ReadTimeout}, nil
+	case sourceGPSD:
+		return &gpsdSource{addr: withDefaultPort(c.GPSDServer, defaultGPSDPort), readTimeout: c.ReadTimeout}, nil
+	case sourceSerial:
+		return &serialSource{device: c.SerialDevice, baud: c.SerialBaud, readTimeout: c.ReadTimeout}, nil
+	case sourceUDP:
+		return &udpSource{addr: c.UDPListen, readTimeout: c.ReadTimeout}, nil
+	case sourceTCP:
+		if c.TCPServer == "" {
+			return nil, errors.New("missing tcp_server for the tcp source")
+		}
+		return &tcpSource{addr: withDefaultPort(c.TCPServer, defaultNMEAPort), readTimeout: c.ReadTimeout}, nil
+	case sourceReplay:
+		if c.ReplayFile == "" {
+			return nil, errors.New("missing replay_file for the replay source")
+		}
+		return &replaySource{file: c.ReplayFile, speed: c.ReplaySpeed}, nil
+	default:
+		return nil, fmt.Errorf("unknown source %q, expected %s, %s, %s, %s, %s or %s", c.Source, sourceMifi, sourceGPSD, sourceSerial, sourceUDP, sourceTCP, sourceReplay)
+	}
+}
+
+// withDefaultPort adds port to addr if it doesn't have one
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// deadlineReader is something, like a connection or a device file, whose
+// reads can time out
+type deadlineReader interface {
+	io.ReadCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// readConnLines sends each line read from conn to lines until it fails or ctx
+// is done, failing if nothing arrives within readTimeout. Lines skip returns
+// true for aren't sent.
+func readConnLines(ctx context.Context, conn deadlineReader, readTimeout time.Duration, lines chan<- []byte, skip func(line []byte) bool) error {
+	// unblock reads when we're cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	scanner := newNMEAScanner(conn)
+	for {
+		if readTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+				return err
+			}
+		}
+		ok := scanner.Scan()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !ok {
+			err := scanner.Err()
+			if err == nil {
+				return errors.New("reached end of connection")
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("no data in %s", readTimeout)
+			}
+			return err
+		}
+		line := scanner.Bytes()
+		if skip != nil && skip(line) {
+			continue
+		}
+		// the scanner reuses its buffer
+		if !sendLine(ctx, lines, append([]byte(nil), line...)) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sendLine sends a line read by a source, returning false if ctx is done
+func sendLine(ctx context.Context, lines chan<- []byte, line []byte) bool {
+	select {
+	case lines <- line:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// speedMonitor reports going over a speed limit for a while, once each time
+type speedMonitor struct {
+	// when we went over the limit, zero while under it
+	over    time.Time
+	alerted bool
+}
+
+// Update tracks a new fix at t, returning an event once the speed has been
+// over limit km/h for longer than after. A limit of 0 disables alerts.
+func (s *speedMonitor) Update(rmc *nmea.RMC, at time.Time, limit float64, after time.Duration) *Event {
+	kph := rmc.Speed * knotsToKPH
+	if limit <= 0 || kph <= limit {
+		s.over = time.Time{}
+		s.alerted = false
+		return nil
+	}
+	if s.over.IsZero() {
+		s.over = at
+	}
+	if s.alerted || at.Sub(s.over) < after {
+		return nil
+	}
+	s.alerted = true
+	return &Event{
+		Type:      eventSpeedExceeded,
+		Time:      at,
+		Latitude:  rmc.Latitude,
+		Longitude: rmc.Longitude,
+		Message:   fmt.Sprintf("going %.0f km/h, over %.0f km/h for %s", kph, limit, at.Sub(s.over).Round(time.Second)),
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema is a plain gps_logs table for SQLite, which doesn't have
+// PostGIS, so positions are stored as separate columns. Altitude is null for
+// 2D points. Otherwise columns are named like the Postgres table, so queries
+// like pruning work on either. Trips and events are stored like in Postgres.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS gps_logs (
+	pk INTEGER PRIMARY KEY AUTOINCREMENT,
+	logged_at TIMESTAMP NOT NULL,
+	gps_timestamp TIMESTAMP,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	altitude REAL,
+	gps_speed REAL,
+	gps_course REAL,
+	accuracy_m REAL,
+	raw_latitude REAL,
+	raw_longitude REAL,
+	raw_altitude REAL,
+	lat_stddev_m REAL,
+	lon_stddev_m REAL,
+	alt_stddev_m REAL,
+	heading REAL,
+	trip_id TEXT
+);
+CREATE TABLE IF NOT EXISTS trips (
+	id TEXT PRIMARY KEY,
+	started_at TIMESTAMP NOT NULL,
+	ended_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS events (
+	pk INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_time TIMESTAMP NOT NULL,
+	event_type TEXT NOT NULL,
+	name TEXT,
+	latitude REAL,
+	longitude REAL,
+	message TEXT
+)`
+
+// columns added since gps_logs was first created, which older databases need,
+// by name with their types
+var sqliteAddedColumns = [][2]string{
+	{"lat_stddev_m", "REAL"},
+	{"lon_stddev_m", "REAL"},
+	{"alt_stddev_m", "REAL"},
+	{"heading", "REAL"},
+	{"trip_id", "TEXT"},
+}
+
+// sqliteTripUpsert inserts or stretches a trip to cover a fix
+const sqliteTripUpsert = `INSERT INTO trips (id, started_at, ended_at) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET started_at = MIN(started_at, excluded.started_at), ended_at = MAX(ended_at, excluded.ended_at)`
+
+// openSQLite opens, creating if needed, a SQLite database with a gps_logs
+// table
+func openSQLite(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	// SQLite only handles one writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create gps_logs in %s: %w", file, err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to update gps_logs in %s: %w", file, err)
+	}
+	return db, nil
+}
+
+// migrateSQLite adds columns missing from an older gps_logs table
+func migrateSQLite(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('gps_logs')`)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range sqliteAddedColumns {
+		if existing[column[0]] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE gps_logs ADD COLUMN ` + column[0] + ` ` + column[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertSQLite writes fixes to a SQLite gps_logs table
+func insertSQLite(ctx context.Context, tx *sql.Tx, fixes []Fix) error {
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO gps_logs (logged_at, gps_timestamp, latitude, longitude, altitude, gps_speed, gps_course, accuracy_m, raw_latitude, raw_longitude, raw_altitude, lat_stddev_m, lon_stddev_m, alt_stddev_m, heading, trip_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	tripStmt, err := tx.PrepareContext(ctx, sqliteTripUpsert)
+	if err != nil {
+		return err
+	}
+	defer tripStmt.Close()
+	for _, fix := range fixes {
+		var altitude, rawLatitude, rawLongitude, rawAltitude sql.NullFloat64
+		if fix.HasAltitude {
+			altitude = sql.NullFloat64{Float64: fix.Position.Altitude, Valid: true}
+		}
+		if fix.Raw != nil {
+			rawLatitude = sql.NullFloat64{Float64: fix.Raw.Latitude, Valid: true}
+			rawLongitude = sql.NullFloat64{Float64: fix.Raw.Longitude, Valid: true}
+			rawAltitude = sql.NullFloat64{Float64: fix.Raw.Altitude, Valid: true}
+		}
+		var tripID sql.NullString
+		if fix.TripID != "" {
+			tripID = sql.NullString{String: fix.TripID, Valid: true}
+		}
+		var latDeviation, lonDeviation, altDeviation sql.NullFloat64
+		if fix.Deviation != nil {
+			latDeviation = sql.NullFloat64{Float64: fix.Deviation.Latitude, Valid: true}
+			lonDeviation = sql.NullFloat64{Float64: fix.Deviation.Longitude, Valid: true}
+			altDeviation = sql.NullFloat64{Float64: fix.Deviation.Altitude, Valid: true}
+		}
+		if _, err := stmt.ExecContext(ctx,
+			fix.LoggedAt.UTC(),
+			fix.Time.UTC(),
+			fix.Position.Latitude,
+			fix.Position.Longitude,
+			altitude,
+			fix.Speed,
+			fix.Course,
+			fix.Accuracy,
+			rawLatitude,
+			rawLongitude,
+			rawAltitude,
+			latDeviation,
+			lonDeviation,
+			altDeviation,
+			fix.Heading,
+			tripID,
+		); err != nil {
+			return err
+		}
+		if tripID.Valid {
+			if _, err := tripStmt.ExecContext(ctx, fix.TripID, fix.Time.UTC(), fix.Time.UTC()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectSQLite reads up to limit of the oldest fixes from a SQLite gps_logs
+// table, along with their primary keys
+func selectSQLite(ctx context.Context, db *sql.DB, limit int) ([]int64, []Fix, error) {
+	rows, err := db.QueryContext(ctx, `SELECT pk, logged_at, gps_timestamp, latitude, longitude, altitude, gps_speed, gps_course, accuracy_m, raw_latitude, raw_longitude, raw_altitude, lat_stddev_m, lon_stddev_m, alt_stddev_m, heading, trip_id FROM gps_logs ORDER BY pk LIMIT ?`, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	var fixes []Fix
+	for rows.Next() {
+		var id int64
+		var fix Fix
+		var gpsTime sql.NullTime
+		var altitude, speed, course, accuracy, rawLatitude, rawLongitude, rawAltitude, latDeviation, lonDeviation, altDeviation, heading sql.NullFloat64
+		var tripID sql.NullString
+		if err := rows.Scan(&id, &fix.LoggedAt, &gpsTime, &fix.Position.Latitude, &fix.Position.Longitude, &altitude, &speed, &course, &accuracy, &rawLatitude, &rawLongitude, &rawAltitude, &latDeviation, &lonDeviation, &altDeviation, &heading, &tripID); err != nil {
+			return nil, nil, err
+		}
+		fix.Time = gpsTime.Time
+		fix.Position.Altitude = altitude.Float64
+		fix.HasAltitude = altitude.Valid
+		fix.Speed = speed.Float64
+		fix.Course = course.Float64
+		if accuracy.Valid {
+			fix.Accuracy = &accuracy.Float64
+		}
+		if rawLatitude.Valid {
+			fix.Raw = &position{Latitude: rawLatitude.Float64, Longitude: rawLongitude.Float64, Altitude: rawAltitude.Float64}
+		}
+		if latDeviation.Valid {
+			fix.Deviation = &positionDeviation{Latitude: latDeviation.Float64, Longitude: lonDeviation.Float64, Altitude: altDeviation.Float64}
+		}
+		if heading.Valid {
+			fix.Heading = &heading.Float64
+		}
+		fix.TripID = tripID.String
+		ids = append(ids, id)
+		fixes = append(fixes, fix)
+	}
+	return ids, fixes, rows.Err()
+}
+
+// deleteSQLite deletes rows from a SQLite gps_logs table by primary key
+func deleteSQLite(ctx context.Context, db *sql.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM gps_logs WHERE pk IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	return err
+}
+
+// sqliteStorage stores fixes in a local SQLite database, for when running
+// PostGIS isn't practical
+type sqliteStorage struct {
+	db    *sql.DB
+	queue *fixQueue
+	// bounds a whole flush so a stuck disk can't block us forever
+	flushTimeout time.Duration
+	// which optional columns to write
+	storeRaw      bool
+	storeAccuracy bool
+	storeHeading  bool
+
+	// flushes can be triggered manually too, don't let them overlap
+	flushMu sync.Mutex
+}
+
+func newSQLiteStorage(db *sql.DB, queue *fixQueue, flushTimeout time.Duration, storeRaw, storeAccuracy, storeHeading bool) *sqliteStorage {
+	return &sqliteStorage{
+		db:            db,
+		queue:         queue,
+		flushTimeout:  flushTimeout,
+		storeRaw:      storeRaw,
+		storeAccuracy: storeAccuracy,
+		storeHeading:  storeHeading,
+	}
+}
+
+func (s *sqliteStorage) Enqueue(ctx context.Context, fix Fix) (int, error) {
+	return s.queue.Push(ctx, fix)
+}
+
+func (s *sqliteStorage) Queued() (int, int) {
+	return s.queue.Len(), s.queue.Dropped()
+}
+
+func (s *sqliteStorage) Flush(ctx context.Context) (int, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.flushTimeout)
+	defer cancel()
+	// only drop fixes from the queue once they're committed
+	pending := s.queue.Snapshot()
+	log.Printf("pushing GPS data (%d in queue)\n", len(pending.fixes))
+	fixes := make([]Fix, len(pending.fixes))
+	for i, fix := range pending.fixes {
+		if !s.storeRaw {
+			fix.Raw = nil
+		}
+		if !s.storeAccuracy {
+			fix.Accuracy = nil
+			fix.Deviation = nil
+		}
+		if !s.storeHeading {
+			fix.Heading = nil
+		}
+		fixes[i] = fix
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start db txn: %w", err)
+	}
+	// no-op once committed
+	defer tx.Rollback()
+	if err := insertSQLite(ctx, tx, fixes); err != nil {
+		return 0, fmt.Errorf("failed to insert to DB: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit db txn: %w", err)
+	}
+	s.queue.Remove(pending)
+	return len(pending.fixes), nil
+}
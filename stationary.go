@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrStationary is returned for fixes that haven't moved from the last logged
+// one
+var ErrStationary = fmt.Errorf("stationary")
+
+// stationaryFilter skips logging fixes while parked, so a night in one place
+// doesn't write the same point over and over
+type stationaryFilter struct {
+	m sync.Mutex
+
+	// the last logged fix
+	last *Fix
+}
+
+// Check returns ErrStationary if fix is within radius meters of the last
+// logged fix, unless heartbeat has passed since it, so there's still a "still
+// here" point now and then. Otherwise fix is taken to be logged. A radius of 0
+// disables the check.
+func (f *stationaryFilter) Check(fix Fix, radius float64, heartbeat time.Duration) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if radius > 0 && f.last != nil && fix.Time.Sub(f.last.Time) < heartbeat {
+		d := haversine(f.last.Position.Latitude, f.last.Position.Longitude, fix.Position.Latitude, fix.Position.Longitude)
+		if d <= radius {
+			return fmt.Errorf("%w: moved %.0f m since %s", ErrStationary, d, f.last.Time.Format(time.RFC3339))
+		}
+	}
+	f.last = &fix
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Fix is a single logged location
+type Fix struct {
+	// when it was logged
+	LoggedAt time.Time
+	// the GPS's time for the fix
+	Time time.Time
+	// the position to store, smoothed when smoothing is enabled
+	Position position
+	// the unsmoothed position, only set when smoothing
+	Raw *position
+	// false when we don't know the altitude
+	HasAltitude bool
+	// in knots
+	Speed  float64
+	Course float64
+	// estimated horizontal accuracy in meters, nil without DOP data
+	Accuracy *float64
+	// the receiver's own error estimate, nil without GST data
+	Deviation *positionDeviation
+	// true heading from a compass, nil without HDT or THS data
+	Heading *float64
+	// the trip it was logged during, empty when stopped or without trip
+	// tracking
+	TripID string
+}
+
+// Storage stores logged fixes. Fixes are queued and written in batches, so
+// logging doesn't depend on the storage being reachable. Logging only goes
+// through this, so adding a destination means implementing it, usually around
+// a fixQueue, and choosing it with the storage setting in main.
+type Storage interface {
+	// Enqueue queues a fix to be written by the next Flush, returning how
+	// many are queued
+	Enqueue(ctx context.Context, fix Fix) (int, error)
+	// Flush writes everything queued, returning how many fixes were written
+	Flush(ctx context.Context) (int, error)
+	// Queued returns how many fixes are waiting to be written, and how many
+	// have been dropped because the queue was full
+	Queued() (queued, dropped int)
+}
+
+// discardStorage doesn't store anything, for running without a DB
+type discardStorage struct{}
+
+func (discardStorage) Enqueue(ctx context.Context, fix Fix) (int, error) { return 0, nil }
+func (discardStorage) Flush(ctx context.Context) (int, error)            { return 0, nil }
+func (discardStorage) Queued() (int, int)                                { return 0, 0 }
+
+// storage backends
+const (
+	storagePostgres = "postgres"
+	storageSQLite   = "sqlite"
+)
+
+// postgresStorage stores fixes in the gps_logs table of a PostGIS database
+type postgresStorage struct {
+	db    *sql.DB
+	queue *fixQueue
+	// bounds a whole flush so a hung db can't block us forever
+	flushTimeout time.Duration
+	// which optional columns to write
+	storeRaw      bool
+	storeAccuracy bool
+	storeHeading  bool
+
+	// flushes can be triggered manually too, don't let them overlap
+	flushMu sync.Mutex
+}
+
+func newPostgresStorage(db *sql.DB, queue *fixQueue, flushTimeout time.Duration, storeRaw, storeAccuracy, storeHeading bool) *postgresStorage {
+	return &postgresStorage{
+		db:            db,
+		queue:         queue,
+		flushTimeout:  flushTimeout,
+		storeRaw:      storeRaw,
+		storeAccuracy: storeAccuracy,
+		storeHeading:  storeHeading,
+	}
+}
+
+func (s *postgresStorage) Enqueue(ctx context.Context, fix Fix) (int, error) {
+	return s.queue.Push(ctx, fix)
+}
+
+func (s *postgresStorage) Queued() (int, int) {
+	return s.queue.Len(), s.queue.Dropped()
+}
+
+// insert returns the insert into gps_logs for a fix
+func (s *postgresStorage) insert(fix Fix) queuedOp {
+	var insert logInsert
+	// don't depend on the host's time zone
+	insert.add("logged_at", fix.LoggedAt.UTC())
+	insert.add("gps_timestamp", fix.Time.UTC())
+	if fix.HasAltitude {
+		insert.addGeography("gps_geometry", fix.Position.ewkt())
+	} else {
+		// without altitude, log a 2D point rather than nothing
+		insert.addGeography("gps_geometry", fix.Position.ewkt2D())
+	}
+	insert.add("gps_speed", fix.Speed)
+	insert.add("gps_course", fix.Course)
+	if s.storeRaw && fix.Raw != nil {
+		insert.addGeography("gps_raw_geometry", fix.Raw.ewkt())
+	}
+	if s.storeAccuracy && fix.Accuracy != nil {
+		insert.add("accuracy_m", *fix.Accuracy)
+	}
+	if s.storeAccuracy && fix.Deviation != nil {
+		insert.add("lat_stddev_m", fix.Deviation.Latitude)
+		insert.add("lon_stddev_m", fix.Deviation.Longitude)
+		insert.add("alt_stddev_m", fix.Deviation.Altitude)
+	}
+	if s.storeHeading && fix.Heading != nil {
+		insert.add("heading", *fix.Heading)
+	}
+	if fix.TripID != "" {
+		insert.add("trip_id", fix.TripID)
+	}
+	return insert.op()
+}
+
+// tripUpsert returns the insert or update of a fix's trip, stretching it to
+// cover the fix
+func tripUpsert(fix Fix) queuedOp {
+	return queuedOp{
+		query: `INSERT INTO trips (id, started_at, ended_at) VALUES ($1, $2, $2) ON CONFLICT (id) DO UPDATE SET started_at = LEAST(trips.started_at, EXCLUDED.started_at), ended_at = GREATEST(trips.ended_at, EXCLUDED.ended_at)`,
+		args:  []interface{}{fix.TripID, fix.Time.UTC()},
+	}
+}
+
+func (s *postgresStorage) Flush(ctx context.Context) (int, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	// only drop fixes from the queue once they're committed
+	pending := s.queue.Snapshot()
+	log.Printf("pushing GPS data (%d in queue)\n", len(pending.fixes))
+	if err := s.write(ctx, pending.fixes); err != nil {
+		return 0, err
+	}
+	s.queue.Remove(pending)
+	return len(pending.fixes), nil
+}
+
+// write inserts fixes in a single transaction
+func (s *postgresStorage) write(ctx context.Context, fixes []Fix) error {
+	ctx, cancel := context.WithTimeout(ctx, s.flushTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start db txn: %w", err)
+	}
+	// no-op once committed
+	defer tx.Rollback()
+	for _, fix := range fixes {
+		op := s.insert(fix)
+		if _, err := tx.ExecContext(ctx, op.query, op.args...); err != nil {
+			return fmt.Errorf("failed to insert to DB: %w", err)
+		}
+		if fix.TripID != "" {
+			op := tripUpsert(fix)
+			if _, err := tx.ExecContext(ctx, op.query, op.args...); err != nil {
+				return fmt.Errorf("failed to update trip: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit db txn: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// tcpSource reads a plain TCP NMEA stream, as served by some hotspots and GPS
+// servers, without the mifi's HTTP 0.9 wrapping
+type tcpSource struct {
+	addr string
+	// how long the stream can go quiet before we consider it dead
+	readTimeout time.Duration
+}
+
+func (s *tcpSource) String() string {
+	return "TCP stream at " + s.addr
+}
+
+func (s *tcpSource) Read(ctx context.Context, lines chan<- []byte) error {
+	dialer := &net.Dialer{Timeout: s.readTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return readConnLines(ctx, conn, s.readTimeout, lines, nil)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttledSender hands fixes to a goroutine sending them somewhere, at most
+// one per interval. Fixes are dropped rather than queued while sending is
+// slow, so a slow or unreachable server never holds up reading the stream.
+type throttledSender struct {
+	// 0 sends every fix
+	interval time.Duration
+
+	m        sync.Mutex
+	lastSent time.Time
+
+	// read by the sending goroutine
+	fixes chan currentFix
+}
+
+func newThrottledSender(interval time.Duration) throttledSender {
+	return throttledSender{
+		interval: interval,
+		fixes:    make(chan currentFix, 1),
+	}
+}
+
+// Publish queues a fix to be sent, unless one was sent within the interval or
+// one is still being sent
+func (s *throttledSender) Publish(fix currentFix) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if time.Since(s.lastSent) < s.interval {
+		return
+	}
+	select {
+	case s.fixes <- fix:
+		s.lastSent = time.Now()
+	default:
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottledSender(t *testing.T) {
+	s := newThrottledSender(time.Hour)
+	s.Publish(currentFix{Speed: 1})
+	// within the interval
+	s.Publish(currentFix{Speed: 2})
+	if fix := <-s.fixes; fix.Speed != 1 {
+		t.Errorf("expected the first fix to be sent, got %+v", fix)
+	}
+	select {
+	case fix := <-s.fixes:
+		t.Errorf("expected fixes within the interval to be dropped, got %+v", fix)
+	default:
+	}
+
+	s = newThrottledSender(0)
+	s.Publish(currentFix{Speed: 1})
+	// the first is still being sent
+	s.Publish(currentFix{Speed: 2})
+	if fix := <-s.fixes; fix.Speed != 1 {
+		t.Errorf("expected the first fix to be sent, got %+v", fix)
+	}
+	s.Publish(currentFix{Speed: 3})
+	if fix := <-s.fixes; fix.Speed != 3 {
+		t.Errorf("expected fixes to be sent once the last was, got %+v", fix)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// how long to wait for a Traccar server
+const traccarTimeout = 10 * time.Second
+
+// traccarForwarder sends fixes to a Traccar server with the OsmAnd protocol,
+// so the device shows up there like a phone running Traccar Client.
+type traccarForwarder struct {
+	throttledSender
+
+	// the OsmAnd port's URL, like http://traccar:5055
+	url      string
+	deviceID string
+	client   *http.Client
+}
+
+func newTraccarForwarder(endpoint, deviceID string, interval time.Duration) *traccarForwarder {
+	f := &traccarForwarder{
+		throttledSender: newThrottledSender(interval),
+		url:             endpoint,
+		deviceID:        deviceID,
+		client:          &http.Client{Timeout: traccarTimeout},
+	}
+	go f.run()
+	return f
+}
+
+func (f *traccarForwarder) run() {
+	for fix := range f.fixes {
+		if err := f.send(fix); err != nil {
+			log.Printf("error forwarding to Traccar: %v\n", err)
+		}
+	}
+}
+
+// osmandQuery returns the OsmAnd protocol parameters for a fix
+func osmandQuery(fix currentFix, deviceID string) url.Values {
+	q := url.Values{}
+	q.Set("id", deviceID)
+	q.Set("timestamp", strconv.FormatInt(fix.Time.Unix(), 10))
+	q.Set("lat", strconv.FormatFloat(fix.Latitude, 'f', -1, 64))
+	q.Set("lon", strconv.FormatFloat(fix.Longitude, 'f', -1, 64))
+	// Traccar takes knots
+	q.Set("speed", strconv.FormatFloat(fix.Speed, 'f', -1, 64))
+	q.Set("bearing", strconv.FormatFloat(fix.Course, 'f', -1, 64))
+	if fix.Altitude != nil {
+		q.Set("altitude", strconv.FormatFloat(*fix.Altitude, 'f', -1, 64))
+	}
+	if fix.Accuracy != nil {
+		q.Set("accuracy", strconv.FormatFloat(*fix.Accuracy, 'f', -1, 64))
+	}
+	if fix.HDOP != nil {
+		q.Set("hdop", strconv.FormatFloat(*fix.HDOP, 'f', -1, 64))
+	}
+	return q
+}
+
+func (f *traccarForwarder) send(fix currentFix) error {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return fmt.Errorf("invalid Traccar URL")
+	}
+	u.RawQuery = osmandQuery(fix, f.deviceID).Encode()
+	ctx, cancel := context.WithTimeout(context.Background(), traccarTimeout)
+	defer cancel()
+	// Traccar Client POSTs with the parameters in the URL
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Traccar responded %s", res.Status)
+	}
+	return nil
+}
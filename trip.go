@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// tripTracker splits travel into trips, starting a new one when moving after
+// being stopped for a while, so logged points can be grouped by trip
+type tripTracker struct {
+	m sync.Mutex
+
+	// the current trip, empty when stopped
+	id string
+	// when we were last moving
+	lastMoving time.Time
+}
+
+// tripID returns the ID for a trip starting at t, which is also readable as
+// when it started
+func tripID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Update tracks a new fix at t, ending the current trip once stopped for
+// longer than stopAfter, and starting a new one when moving again. 0 disables
+// trip tracking.
+func (t *tripTracker) Update(rmc *nmea.RMC, at time.Time, stopAfter time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if stopAfter <= 0 {
+		t.id = ""
+		return
+	}
+	stopped := at.Sub(t.lastMoving) > stopAfter
+	if rmc.Speed >= minMovingSpeed {
+		if t.id == "" || stopped {
+			t.id = tripID(at)
+		}
+		t.lastMoving = at
+	} else if stopped {
+		t.id = ""
+	}
+}
+
+// Current returns the current trip's ID, or empty when stopped
+func (t *tripTracker) Current() string {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.id
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// the port commonly used for NMEA over UDP and TCP
+const defaultNMEAPort = "10110"
+
+// udpSource listens for NMEA sentences sent over UDP, like by marine
+// multiplexers and some phone apps
+type udpSource struct {
+	addr string
+	// how long we can go without a packet before we consider the sender dead
+	readTimeout time.Duration
+}
+
+func (s *udpSource) String() string {
+	return "UDP on " + s.addr
+}
+
+func (s *udpSource) Read(ctx context.Context, lines chan<- []byte) error {
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// unblock reads when we're cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	// larger than any UDP packet
+	buf := make([]byte, 65536)
+	for {
+		if s.readTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.readTimeout)); err != nil {
+				return err
+			}
+		}
+		n, _, err := conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("no data in %s", s.readTimeout)
+		}
+		if err != nil {
+			return err
+		}
+		// a packet can hold several lines
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			line = bytes.TrimRight(line, "\r")
+			if len(line) == 0 {
+				continue
+			}
+			if !sendLine(ctx, lines, append([]byte(nil), line...)) {
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// failed webhooks are retried this many times, backing off from
+// webhookRetryDelay, before going to the dead letter log
+const (
+	webhookRetries    = 5
+	webhookRetryDelay = time.Second
+	webhookTimeout    = 10 * time.Second
+)
+
+// webhookNotifier POSTs events as JSON to URLs. Events are sent in the
+// background, and ones that can't be delivered are written to a dead letter
+// log so they aren't lost silently.
+type webhookNotifier struct {
+	urls []string
+	// file to append undeliverable events to, empty to only log them
+	deadLetter string
+	client     *http.Client
+	events     chan Event
+}
+
+// newWebhookNotifier sends events to comma separated URLs
+func newWebhookNotifier(urls, deadLetter string) *webhookNotifier {
+	n := &webhookNotifier{
+		deadLetter: deadLetter,
+		client:     &http.Client{Timeout: webhookTimeout},
+		events:     make(chan Event, 100),
+	}
+	for _, target := range strings.Split(urls, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			n.urls = append(n.urls, target)
+		}
+	}
+	go n.run()
+	return n
+}
+
+func (n *webhookNotifier) Notify(e Event) {
+	select {
+	case n.events <- e:
+	default:
+		n.dead("", e, fmt.Errorf("too many events waiting to be sent"))
+	}
+}
+
+func (n *webhookNotifier) run() {
+	for e := range n.events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("error encoding webhook payload: %v\n", err)
+			continue
+		}
+		for _, target := range n.urls {
+			if err := n.send(target, payload); err != nil {
+				n.dead(target, e, err)
+			}
+		}
+	}
+}
+
+// send POSTs a payload, retrying with exponential backoff
+func (n *webhookNotifier) send(target string, payload []byte) error {
+	delay := webhookRetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = n.post(target, payload); err == nil {
+			return nil
+		}
+		if attempt == webhookRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *webhookNotifier) post(target string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
+
+// withoutURL strips the URL from an HTTP client error, since URLs can have
+// credentials in them
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// dead records an event that couldn't be sent
+func (n *webhookNotifier) dead(target string, e Event, err error) {
+	// only the host, URLs can have credentials in them
+	var host string
+	if u, parseErr := url.Parse(target); parseErr == nil {
+		host = u.Host
+	}
+	log.Printf("failed to send %s event to webhook %s: %v\n", e.Type, host, err)
+	if n.deadLetter == "" {
+		return
+	}
+	line, jsonErr := json.Marshal(struct {
+		Host  string `json:"host,omitempty"`
+		Error string `json:"error"`
+		Event Event  `json:"event"`
+	}{host, err.Error(), e})
+	if jsonErr != nil {
+		log.Printf("error encoding dead letter: %v\n", jsonErr)
+		return
+	}
+	f, fileErr := os.OpenFile(n.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if fileErr != nil {
+		log.Printf("error writing dead letter: %v\n", fileErr)
+		return
+	}
+	defer f.Close()
+	if _, fileErr := f.Write(append(line, '\n')); fileErr != nil {
+		log.Printf("error writing dead letter: %v\n", fileErr)
+	}
+}